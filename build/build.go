@@ -0,0 +1,342 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hwang-fu/minicontainer/cgroup"
+	"github.com/hwang-fu/minicontainer/cmd"
+	"github.com/hwang-fu/minicontainer/container"
+	"github.com/hwang-fu/minicontainer/fs"
+	"github.com/hwang-fu/minicontainer/image"
+	"github.com/hwang-fu/minicontainer/state"
+)
+
+// Options configures an image build.
+type Options struct {
+	Tag        string // Reference for the built image ("name:tag")
+	Dockerfile string // Path to the Dockerfile (default: <context>/Dockerfile)
+	ContextDir string // Build context directory, the root for COPY/ADD sources
+}
+
+// builder holds the image being assembled while the instructions run.
+type builder struct {
+	contextDir string
+	image      image.LocalImage
+}
+
+// RunBuild builds an image from a Dockerfile.
+// Usage: build -t NAME[:TAG] [-f DOCKERFILE] <context>
+func RunBuild(args []string) {
+	var opts Options
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-t", "--tag":
+			if i+1 < len(args) {
+				opts.Tag = args[i+1]
+				i++
+			}
+		case "-f", "--file":
+			if i+1 < len(args) {
+				opts.Dockerfile = args[i+1]
+				i++
+			}
+		default:
+			opts.ContextDir = args[i]
+		}
+	}
+
+	if opts.Tag == "" || opts.ContextDir == "" {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer build -t <name[:tag]> [-f <Dockerfile>] <context>")
+		os.Exit(1)
+	}
+
+	meta, err := Build(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Successfully built %s\n", meta.ID[:12])
+	fmt.Printf("Successfully tagged %s:%s\n", meta.Name, meta.Tag)
+}
+
+// Build runs the instructions of a Dockerfile and stores the result as an image.
+// RUN steps execute in a container on the layers built so far, and each RUN,
+// COPY and ADD step's overlay upper directory becomes a new layer. Other
+// instructions only change the image config.
+//
+// Supported instructions: FROM, RUN, COPY, ADD (local files), ENV, WORKDIR,
+// USER, CMD, ENTRYPOINT, EXPOSE and LABEL.
+//
+// Parameters:
+//   - opts: image tag, Dockerfile path and build context
+//
+// Returns:
+//   - *image.ImageMetadata: the built image
+//   - error: the first instruction that failed
+func Build(opts Options) (*image.ImageMetadata, error) {
+	// Step 1: Parse the Dockerfile (and check the tag before doing any work)
+	if _, err := image.ParseTagReference(opts.Tag); err != nil {
+		return nil, err
+	}
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(opts.ContextDir, "Dockerfile")
+	}
+	file, err := os.Open(dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("open Dockerfile: %w", err)
+	}
+	instructions, err := ParseDockerfile(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(instructions) == 0 || instructions[0].Command != "FROM" {
+		return nil, fmt.Errorf("%s: the first instruction must be FROM", dockerfile)
+	}
+
+	contextDir, err := filepath.Abs(opts.ContextDir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(contextDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", opts.ContextDir)
+	}
+
+	// Step 2: Execute the instructions in order
+	b := &builder{contextDir: contextDir}
+	for i, inst := range instructions {
+		fmt.Printf("Step %d/%d : %s\n", i+1, len(instructions), inst)
+		if err := b.execute(inst, i == 0); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", inst.Line, inst.Command, err)
+		}
+	}
+
+	// Step 3: Store the image
+	return image.StoreImage(opts.Tag, b.image)
+}
+
+// execute runs a single instruction.
+func (b *builder) execute(inst Instruction, first bool) error {
+	switch inst.Command {
+	case "FROM":
+		if !first {
+			return fmt.Errorf("multi-stage builds are not supported")
+		}
+		return b.from(inst.Args)
+
+	case "RUN":
+		if err := b.run(inst.Args); err != nil {
+			return err
+		}
+
+	case "COPY", "ADD":
+		if err := b.copy(inst); err != nil {
+			return err
+		}
+
+	case "ENV", "WORKDIR", "USER", "CMD", "ENTRYPOINT", "EXPOSE", "LABEL":
+		if err := image.ApplyChange(b.image.Config, inst.String()); err != nil {
+			return err
+		}
+		b.addHistory(inst, true)
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported instruction")
+	}
+
+	b.addHistory(inst, false)
+	return nil
+}
+
+// from starts the build from a local image, pulling it if needed,
+// or from an empty filesystem for "scratch".
+func (b *builder) from(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 1 {
+		return fmt.Errorf("multi-stage builds are not supported")
+	}
+	ref := fields[0]
+
+	if ref == "scratch" {
+		b.image = image.LocalImage{Config: &image.ImageConfig{}}
+		return nil
+	}
+
+	// Step 1: Find the base image locally or pull it
+	meta, err := image.ResolveImage(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		meta, err = image.Pull(ref, image.PullOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	if len(meta.DiffIDs) != len(meta.Layers) {
+		return fmt.Errorf("base image %s has no layer diff_ids, pull or import it again", ref)
+	}
+
+	// Step 2: Start from its layers and config
+	config, err := image.LoadConfig(meta.ID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("load base config: %w", err)
+		}
+		config = &image.ImageConfig{}
+	}
+	b.image = image.LocalImage{
+		Layers:   append([]string{}, meta.Layers...),
+		DiffIDs:  append([]string{}, meta.DiffIDs...),
+		Size:     meta.Size,
+		Platform: meta.Platform,
+		Config:   config,
+	}
+	return nil
+}
+
+// run executes a command in a container on the current layers and adds the
+// container's changes as a layer.
+func (b *builder) run(args string) error {
+	if len(b.image.Layers) == 0 {
+		return fmt.Errorf("no base image to run in (FROM scratch has no shell)")
+	}
+	command, err := image.ParseCommand(args)
+	if err != nil {
+		return err
+	}
+
+	// Step 1: Run the command with the image's environment, working dir and user
+	cfg := cmd.ContainerConfig{
+		Env:        b.image.Config.Config.Env,
+		WorkingDir: b.image.Config.Config.WorkingDir,
+		User:       b.image.Config.Config.User,
+	}
+	for _, diffID := range b.image.DiffIDs {
+		cfg.LayerPaths = append(cfg.LayerPaths, image.SnapshotDir(diffID))
+	}
+	cs, err := container.RunToCompletion(cfg, command, os.Stdout, os.Stderr)
+	if cs != nil {
+		defer removeContainer(cs)
+	}
+	if err != nil {
+		return err
+	}
+	if cs.ExitCode != 0 {
+		return fmt.Errorf("command returned a non-zero code: %d", cs.ExitCode)
+	}
+
+	// Step 2: The container's upper directory becomes the next layer
+	return b.addLayer(cs.UpperDir)
+}
+
+// copy adds files from the build context as a new layer.
+// The files are copied into an overlay of the current layers, so existing
+// directories and symlinks in the image are taken into account.
+func (b *builder) copy(inst Instruction) error {
+	// Step 1: Parse sources and destination (JSON array or space separated)
+	var args []string
+	if strings.HasPrefix(inst.Args, "[") {
+		if err := json.Unmarshal([]byte(inst.Args), &args); err != nil {
+			return fmt.Errorf("invalid JSON array %s: %w", inst.Args, err)
+		}
+	} else {
+		args = strings.Fields(inst.Args)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			return fmt.Errorf("flag %s is not supported", arg)
+		}
+		if inst.Command == "ADD" && strings.Contains(arg, "://") {
+			return fmt.Errorf("only local files are supported, not %s", arg)
+		}
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("requires at least one source and a destination")
+	}
+	sources, dest := args[:len(args)-1], args[len(args)-1]
+
+	// Relative destinations are inside the working directory.
+	// A trailing "/" (or ".") marks a directory and is kept through path.Join.
+	isDir := strings.HasSuffix(dest, "/") || dest == "." || strings.HasSuffix(dest, "/.")
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.image.Config.Config.WorkingDir, dest)
+	}
+	if isDir && !strings.HasSuffix(dest, "/") {
+		dest += "/"
+	}
+
+	// Step 2: Mount an overlay of the current layers.
+	// Named like container overlays so `prune` removes it after a crash.
+	baseDir, err := os.MkdirTemp("", "minicontainer-overlay-build-")
+	if err != nil {
+		return fmt.Errorf("create overlay dir: %w", err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	var lowerDirs []string
+	for _, diffID := range b.image.DiffIDs {
+		lowerDirs = append(lowerDirs, image.SnapshotDir(diffID))
+	}
+	if len(lowerDirs) == 0 {
+		// FROM scratch: overlayfs needs a lower directory, use an empty one
+		empty := filepath.Join(baseDir, "empty")
+		if err := os.Mkdir(empty, 0o755); err != nil {
+			return fmt.Errorf("create overlay dir: %w", err)
+		}
+		lowerDirs = []string{empty}
+	}
+	overlay, cleanup, err := fs.SetupOverlayfs(baseDir, lowerDirs)
+	if err != nil {
+		return err
+	}
+
+	// Step 3: Copy the files and turn the upper directory into a layer
+	err = copyFromContext(b.contextDir, overlay.MergedDir, sources, dest)
+	if cleanupErr := cleanup(); err == nil {
+		err = cleanupErr
+	}
+	if err != nil {
+		return err
+	}
+	return b.addLayer(overlay.UpperDir)
+}
+
+// addLayer archives an upper directory into the image store and stacks it
+// on top of the image.
+func (b *builder) addLayer(upperDir string) error {
+	digest, diffID, size, err := image.CreateLayer(upperDir)
+	if err != nil {
+		return err
+	}
+	b.image.Layers = append(b.image.Layers, digest)
+	b.image.DiffIDs = append(b.image.DiffIDs, diffID)
+	b.image.Size += size
+
+	fmt.Printf(" ---> %s\n", strings.TrimPrefix(digest, "sha256:")[:12])
+	return nil
+}
+
+// addHistory records an instruction in the image history.
+func (b *builder) addHistory(inst Instruction, emptyLayer bool) {
+	now := time.Now().UTC()
+	b.image.Config.History = append(b.image.Config.History, image.HistoryEntry{
+		Created:    &now,
+		CreatedBy:  inst.String(),
+		EmptyLayer: emptyLayer,
+	})
+}
+
+// removeContainer deletes a finished build container and its state.
+func removeContainer(cs *state.ContainerState) {
+	cgroup.RemoveContainerCgroup(cs.ID)
+	fs.UnmountIfMounted(cs.MergedDir)
+	os.RemoveAll(state.ContainerDir(cs.ID))
+}
@@ -0,0 +1,263 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxSymlinkHops bounds symlink resolution inside the container root,
+// matching the kernel's limit for path lookups.
+const maxSymlinkHops = 40
+
+// copyFromContext copies files from the build context into a container root,
+// following COPY semantics:
+//   - sources are relative to the context (glob patterns allowed) and cannot leave it
+//   - a directory source has its contents copied, not the directory itself
+//   - a file is copied into dest when dest ends with "/" or is an existing
+//     directory, otherwise it is copied to dest
+//
+// Copied files are owned by root and keep their permissions and modification times.
+//
+// Parameters:
+//   - contextDir: build context directory on the host
+//   - rootDir: merged overlay view of the image being built
+//   - sources: source paths or patterns, relative to the context
+//   - dest: absolute destination path inside the image
+//
+// Returns:
+//   - error: if a source is missing or the copy fails
+func copyFromContext(contextDir, rootDir string, sources []string, dest string) error {
+	// Step 1: Expand sources inside the context
+	var matches []string
+	for _, src := range sources {
+		// Rooting the pattern at "/" before joining keeps ".." inside the context
+		pattern := filepath.Join(contextDir, filepath.Clean("/"+src))
+		found, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid source %q: %w", src, err)
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("%s: no such file or directory in build context", src)
+		}
+		matches = append(matches, found...)
+	}
+
+	// Step 2: Decide whether dest names a directory
+	destPath, err := resolveInRootfs(rootDir, dest)
+	if err != nil {
+		return err
+	}
+	destIsDir := strings.HasSuffix(dest, "/") || len(matches) > 1
+	if info, err := os.Stat(destPath); err == nil && info.IsDir() {
+		destIsDir = true
+	}
+
+	// Step 3: Copy each source
+	for _, src := range matches {
+		info, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+
+		target := dest
+		if !info.IsDir() && destIsDir {
+			target = path.Join(dest, filepath.Base(src))
+		}
+		if err := copyTree(src, rootDir, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies src (a file, symlink or directory) to target inside rootDir.
+// For a directory, target is the directory its contents are copied into.
+func copyTree(src, rootDir, target string) error {
+	return filepath.WalkDir(src, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, hostPath)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := path.Join(target, filepath.ToSlash(rel))
+		if info.IsDir() {
+			// Directories merge into existing ones, following symlinks like /bin -> usr/bin
+			dst, err := resolveInRootfs(rootDir, entry)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(dst); err == nil && rel == "." {
+				return nil // Only the contents of the source directory are copied
+			}
+			if err := copyEntry(hostPath, dst, info); err != nil {
+				return fmt.Errorf("copy %s to %s: %w", rel, entry, err)
+			}
+			return nil
+		}
+
+		// Files replace the entry itself, so only its parent is resolved
+		parent, err := resolveInRootfs(rootDir, path.Dir(entry))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", path.Dir(entry), err)
+		}
+		if err := copyEntry(hostPath, filepath.Join(parent, path.Base(entry)), info); err != nil {
+			return fmt.Errorf("copy %s to %s: %w", rel, entry, err)
+		}
+		return nil
+	})
+}
+
+// copyEntry copies a single file, symlink or directory (without its
+// contents) from the host to dst, owned by root.
+func copyEntry(src, dst string, info os.FileInfo) error {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		if existing, err := os.Lstat(dst); err == nil && !existing.IsDir() {
+			if err := os.Remove(dst); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(dst, 0o755); err != nil {
+			return err
+		}
+
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := removeNonDir(dst); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+		return os.Lchown(dst, 0, 0)
+
+	case mode.IsRegular():
+		if err := removeNonDir(dst); err != nil {
+			return err
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unsupported file type %s", mode.Type())
+	}
+
+	if err := os.Lchown(dst, 0, 0); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, mode.Perm()|mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, time.Time{}, info.ModTime())
+}
+
+// copyFile copies the contents of a regular file.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// removeNonDir removes dst unless it does not exist; replacing a directory
+// with a file is refused, as in Docker.
+func removeNonDir(dst string) error {
+	info, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("cannot replace directory %s with a file", dst)
+	}
+	return os.Remove(dst)
+}
+
+// resolveInRootfs resolves an absolute path inside a container root the way
+// the container would see it: symlinks are followed, with absolute targets and
+// ".." interpreted relative to rootDir, so the result never leaves rootDir.
+// Missing components are kept as they are (they will be created).
+//
+// Parameters:
+//   - rootDir: container root on the host
+//   - p: path inside the container
+//
+// Returns:
+//   - host path under rootDir
+//   - error if there are too many levels of symbolic links
+func resolveInRootfs(rootDir, p string) (string, error) {
+	resolved := "" // Path resolved so far, relative to rootDir
+	remaining := strings.Split(p, "/")
+	hops := 0
+
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			// ".." at the root stays at the root, like chroot
+			if resolved = path.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(rootDir, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("resolve %s: too many levels of symbolic links", p)
+		}
+		target, err := os.Readlink(filepath.Join(rootDir, next))
+		if err != nil {
+			return "", fmt.Errorf("read symlink %s: %w", next, err)
+		}
+		if path.IsAbs(target) {
+			resolved = ""
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+
+	return filepath.Join(rootDir, resolved), nil
+}
@@ -0,0 +1,90 @@
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instruction is a single Dockerfile instruction.
+type Instruction struct {
+	Line    int    // Line number where the instruction starts (1-based)
+	Command string // Instruction keyword in upper case (e.g. "RUN")
+	Args    string // Everything after the keyword, with continuations joined
+}
+
+// String returns the instruction as written, e.g. "RUN apk add curl".
+func (i Instruction) String() string {
+	return i.Command + " " + i.Args
+}
+
+// ParseDockerfile splits a Dockerfile into instructions.
+// Comment lines (starting with #) and blank lines are skipped, and lines
+// ending with a backslash are joined with the next line.
+//
+// Parameters:
+//   - r: Dockerfile contents
+//
+// Returns:
+//   - []Instruction: instructions in file order
+//   - error: if reading fails or an instruction has no arguments
+func ParseDockerfile(r io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	var current strings.Builder
+	start := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Comments are skipped, even between continued lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			start = lineNo
+		}
+
+		// A trailing backslash continues the instruction on the next line
+		if continued, ok := strings.CutSuffix(line, "\\"); ok {
+			current.WriteString(strings.TrimSpace(continued))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+
+		instruction, err := parseInstruction(start, current.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read Dockerfile: %w", err)
+	}
+
+	// A continuation on the last line ends the instruction
+	if current.Len() > 0 {
+		instruction, err := parseInstruction(start, current.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+// parseInstruction splits a joined instruction line into keyword and arguments.
+func parseInstruction(line int, text string) (Instruction, error) {
+	fields := strings.Fields(text)
+	command := strings.ToUpper(fields[0])
+	args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), fields[0]))
+	if args == "" {
+		return Instruction{}, fmt.Errorf("line %d: %s requires arguments", line, command)
+	}
+	return Instruction{Line: line, Command: command, Args: args}, nil
+}
@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"github.com/hwang-fu/minicontainer/fs"
 	"github.com/hwang-fu/minicontainer/image"
 	"github.com/hwang-fu/minicontainer/state"
+	"golang.org/x/sys/unix"
 )
 
 // RunStop stops a running container.
@@ -50,6 +53,7 @@ func RunRm(idOrName string) {
 	}
 
 	cgroup.RemoveContainerCgroup(cs.ID)
+	fs.UnmountIfMounted(cs.MergedDir)
 	if err := os.RemoveAll(state.ContainerDir(cs.ID)); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to remove container: %v\n", err)
 		os.Exit(1)
@@ -71,11 +75,153 @@ func RunRmAll() {
 			continue
 		}
 		cgroup.RemoveContainerCgroup(cs.ID)
+		fs.UnmountIfMounted(cs.MergedDir)
 		os.RemoveAll(state.ContainerDir(cs.ID))
 		fmt.Println(state.ShortID(cs.ID))
 	}
 }
 
+// RunExport writes a container's filesystem as a flat tar archive.
+// Works for running and stopped containers; the result can be imported with `import`.
+// Usage: export [-o FILE] <container> (writes to stdout without -o)
+func RunExport(args []string) {
+	var idOrName, output string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-o", "--output":
+			if i+1 < len(args) {
+				output = args[i+1]
+				i++
+			}
+		default:
+			idOrName = args[i]
+		}
+	}
+
+	if idOrName == "" {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer export [-o <file>] <container>")
+		os.Exit(1)
+	}
+
+	cs, err := state.FindContainer(idOrName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if output == "" {
+		if _, err := unix.IoctlGetTermios(int(os.Stdout.Fd()), unix.TCGETS); err == nil {
+			fmt.Fprintln(os.Stderr, "error: refusing to write a tar archive to a terminal, use -o or redirect stdout")
+			os.Exit(1)
+		}
+	} else {
+		out, err = os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	err = exportContainer(cs, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if output != "" {
+			os.Remove(output)
+		}
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// exportContainer streams the container's merged filesystem to w.
+// A running container is read through its mounted overlay; a stopped one
+// through a temporary read-only overlay of its kept upper directory.
+func exportContainer(cs *state.ContainerState, w *os.File) error {
+	if cs.UpperDir == "" {
+		return fmt.Errorf("container %s has no saved filesystem", cs.Name)
+	}
+
+	root := cs.MergedDir
+	if !fs.IsMounted(root) {
+		view, cleanup, err := fs.MountReadOnlyView(cs.UpperDir, cs.LowerDirs)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		root = view
+	}
+
+	return image.ExportTree(w, root)
+}
+
+// RunCommit creates a new image from a container's filesystem changes.
+// The container's writable layer is stacked on top of the image it was created from.
+// Usage: commit [-m MESSAGE] [-c CHANGE]... <container> <name[:tag]>
+func RunCommit(args []string) {
+	var positional, changes []string
+	var message string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-m", "--message":
+			if i+1 < len(args) {
+				message = args[i+1]
+				i++
+			}
+		case "-c", "--change":
+			if i+1 < len(args) {
+				changes = append(changes, args[i+1])
+				i++
+			}
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer commit [-m <message>] [-c <change>]... <container> <name[:tag]>")
+		os.Exit(1)
+	}
+
+	cs, err := state.FindContainer(positional[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if cs.Image == "" {
+		fmt.Fprintf(os.Stderr, "error: container %s was not created from an image\n", cs.Name)
+		os.Exit(1)
+	}
+	if cs.UpperDir == "" {
+		fmt.Fprintf(os.Stderr, "error: container %s has no saved filesystem\n", cs.Name)
+		os.Exit(1)
+	}
+
+	// The image ID still finds the base image after its tag moved; containers
+	// created before IDs were recorded only have the reference
+	baseImage := cs.ImageID
+	if baseImage == "" {
+		baseImage = cs.Image
+	}
+
+	meta, err := image.Commit(positional[1], image.CommitOptions{
+		UpperDir:   cs.UpperDir,
+		BaseImage:  baseImage,
+		BaseLayers: cs.LowerDirs,
+		CreatedBy:  strings.Join(cs.Command, " "),
+		Changes:    changes,
+		Message:    message,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "commit failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("sha256:%s\n", meta.ID)
+}
+
 // RunPs lists containers.
 func RunPs(showAll bool) {
 	containers, err := state.ListContainers()
@@ -84,7 +230,7 @@ func RunPs(showAll bool) {
 		os.Exit(1)
 	}
 
-	fmt.Printf("%-12s  %-20s  %-10s  %s\n", "CONTAINER ID", "COMMAND", "STATUS", "NAME")
+	fmt.Printf("%-12s  %-20s  %-20s  %-10s  %s\n", "CONTAINER ID", "IMAGE", "COMMAND", "STATUS", "NAME")
 	for _, c := range containers {
 		if !showAll && c.Status != state.StatusRunning {
 			continue
@@ -93,9 +239,24 @@ func RunPs(showAll bool) {
 		if len(cmdStr) > 20 {
 			cmdStr = cmdStr[:17] + "..."
 		}
-		fmt.Printf("%-12s  %-20s  %-10s  %s\n",
-			state.ShortID(c.ID), cmdStr, c.Status, c.Name)
+		fmt.Printf("%-12s  %-20s  %-20s  %-10s  %s\n",
+			state.ShortID(c.ID), containerImageName(c), cmdStr, c.Status, c.Name)
+	}
+}
+
+// containerImageName returns the image a container was created from for
+// display: its reference, a short ID if it was run by ID, or "-" for --rootfs.
+func containerImageName(c *state.ContainerState) string {
+	name := c.Image
+	switch {
+	case name == "":
+		return "-"
+	case name == c.ImageID:
+		name = name[:12]
+	case len(name) > 20:
+		name = name[:17] + "..."
 	}
+	return name
 }
 
 // RunPrune removes stale overlay directories.
@@ -116,7 +277,7 @@ func RunPrune() {
 // Creates a single-layer image from the tarball that can be used with `run`.
 //
 // Parameters:
-//   - tarballPath: path to the .tar or .tar.gz rootfs archive
+//   - tarballPath: path to the .tar, .tar.gz or .tar.zst rootfs archive
 //   - imageRef: image reference in "name" or "name:tag" format
 func RunImport(tarballPath, imageRef string) {
 	meta, err := image.ImportTarball(tarballPath, imageRef)
@@ -129,17 +290,86 @@ func RunImport(tarballPath, imageRef string) {
 	fmt.Printf("Imported %s:%s (id: %s)\n", meta.Name, meta.Tag, meta.ID[:12])
 }
 
+// RunSave writes a local image to an archive.
+// Usage: save -o FILE [--format docker|oci] <image>
+func RunSave(args []string) {
+	var ref, output string
+	format := image.FormatDocker
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-o", "--output":
+			if i+1 < len(args) {
+				output = args[i+1]
+				i++
+			}
+		case "--format":
+			if i+1 < len(args) {
+				format = args[i+1]
+				i++
+			}
+		default:
+			ref = args[i]
+		}
+	}
+
+	if ref == "" || output == "" {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer save -o <file> [--format docker|oci] <image>")
+		os.Exit(1)
+	}
+
+	if err := image.Save(ref, output, format); err != nil {
+		fmt.Fprintf(os.Stderr, "save failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Saved %s to %s\n", ref, output)
+}
+
+// RunLoad loads images from a docker archive or OCI image layout.
+// Usage: load -i FILE
+func RunLoad(args []string) {
+	var input string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-i", "--input":
+			if i+1 < len(args) {
+				input = args[i+1]
+				i++
+			}
+		}
+	}
+
+	if input == "" {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer load -i <file>")
+		os.Exit(1)
+	}
+
+	metas, err := image.Load(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load failed: %v\n", err)
+		os.Exit(1)
+	}
+	for _, meta := range metas {
+		if meta.Name == "" {
+			fmt.Printf("Loaded image ID: sha256:%s\n", meta.ID)
+			continue
+		}
+		fmt.Printf("Loaded image: %s:%s (id: %s)\n", meta.Name, meta.Tag, meta.ID[:12])
+	}
+}
+
 // ResolveRootfs resolves the rootfs path from config or image reference.
 // If --rootfs is provided, uses that directly.
-// Otherwise, treats the first cmdArg as an image reference and looks it up.
+// Otherwise, treats the first cmdArg as an image reference and looks it up,
+// applying the image config (Entrypoint, Cmd, Env, WorkingDir, User).
 //
 // Parameters:
 //   - cfg: container config (may have RootfsPath set)
 //   - cmdArgs: command arguments (first may be image reference)
 //
 // Returns:
-//   - updated config with RootfsPath set
-//   - remaining command arguments (image reference removed if used)
+//   - updated config with RootfsPath or LayerPaths set
+//   - command to run (image reference removed, image defaults applied)
 //   - error if image lookup fails
 func ResolveRootfs(cfg *ContainerConfig, cmdArgs []string) (*ContainerConfig, []string, error) {
 	// If --rootfs provided, use it directly
@@ -155,19 +385,47 @@ func ResolveRootfs(cfg *ContainerConfig, cmdArgs []string) (*ContainerConfig, []
 	imageRef := cmdArgs[0]
 	cmdArgs = cmdArgs[1:] // Remaining args are the command
 
-	// Look up image to get layer path
-	rootfsPath, err := image.LookupImage(imageRef)
+	// Look up image to get its layer paths (stacked later by overlayfs).
+	// Everything below comes from this one lookup, so a tag moved meanwhile
+	// cannot mix the layers, config and recorded ID of different images
+	meta, layerPaths, err := image.LookupImage(imageRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg.LayerPaths = layerPaths
+
+	// Record the image by tag, or by full ID if it was run by ID
+	cfg.Image = meta.ID
+	if meta.Name != "" {
+		cfg.Image = meta.Name + ":" + meta.Tag
+	}
+	cfg.ImageID = meta.ID
+	cfg.ImageLayers = meta.DiffIDs
+
+	// Images in a scope of the trust policy only run with a valid signature
+	if err := image.VerifyImage(meta); err != nil {
+		return nil, nil, err
+	}
+
+	// Apply the image config: default command, env, working dir and user
+	imgConfig, err := image.LookupConfig(meta.ID)
 	if err != nil {
 		return nil, nil, err
 	}
+	if imgConfig != nil {
+		cmdArgs = applyImageConfig(cfg, imgConfig, cmdArgs)
+	}
 
-	cfg.RootfsPath = rootfsPath
 	return cfg, cmdArgs, nil
 }
 
 // RunImages lists all local images.
-// Displays repository, tag, image ID (short), size, and creation time.
-func RunImages() {
+// Displays repository, tag, image ID (short), platform, size, and creation time.
+//
+// Parameters:
+//   - showDigests: also show the repo digest of each image (--digests)
+func RunImages(showDigests bool) {
 	images, err := image.ListImages()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -175,43 +433,273 @@ func RunImages() {
 	}
 
 	// Print header
-	fmt.Printf("%-15s  %-10s  %-12s  %-10s  %s\n",
-		"REPOSITORY", "TAG", "IMAGE ID", "SIZE", "CREATED")
+	fmt.Printf("%-15s  %-10s  ", "REPOSITORY", "TAG")
+	if showDigests {
+		fmt.Printf("%-71s  ", "DIGEST")
+	}
+	fmt.Printf("%-12s  %-14s  %-10s  %s\n", "IMAGE ID", "PLATFORM", "SIZE", "CREATED")
 
 	// Print each image
 	for _, img := range images {
-		fmt.Printf("%-15s  %-10s  %-12s  %-10s  %s\n",
-			img.Name,
-			img.Tag,
+		platform := img.Platform
+		if platform == "" {
+			platform = "-" // Imported images carry no platform
+		}
+		name, tag := img.Name, img.Tag
+		if name == "" {
+			// Untagged image; images pulled by digest still have a repository
+			name, tag = "<none>", "<none>"
+			if len(img.RepoDigests) > 0 {
+				name, _, _ = strings.Cut(img.RepoDigests[0], "@")
+			}
+		}
+		fmt.Printf("%-15s  %-10s  ", name, tag)
+		if showDigests {
+			digest := img.RepoDigest(name)
+			if digest == "" {
+				digest = "<none>" // Built, imported or loaded, never pulled or pushed
+			}
+			fmt.Printf("%-71s  ", digest)
+		}
+		fmt.Printf("%-12s  %-14s  %-10s  %s\n",
 			img.ID[:12],
+			platform,
 			formatSize(img.Size),
 			formatTimeAgo(img.CreatedAt),
 		)
 	}
 }
 
-// RunRmi removes an image by reference (name:tag or ID).
-// Deletes image metadata and unreferenced layers.
+// RunRmi removes an image reference (name:tag or ID).
+// The tag is removed first; the image and its unreferenced layers are
+// deleted once no tags are left. Images that containers were created from
+// are only deleted with force, and the layers those containers use are kept.
 //
 // Parameters:
 //   - ref: image reference ("name:tag") or image ID (full or short)
-func RunRmi(ref string) {
-	if err := image.RemoveImage(ref); err != nil {
+//   - force: delete the image even if containers use it (--force)
+func RunRmi(ref string, force bool) {
+	containers, err := containerImages()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	untagged, deleted, err := image.RemoveImage(ref, image.RemoveOptions{Force: force, Containers: containers})
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Removed: %s\n", ref)
+	for _, tag := range untagged {
+		fmt.Printf("Untagged: %s\n", tag)
+	}
+	if deleted != "" {
+		fmt.Printf("Deleted: sha256:%s\n", deleted)
+	}
+}
+
+// RunTag creates a tag target that refers to the image source.
+//
+// Parameters:
+//   - source: existing image reference ("name:tag") or image ID
+//   - target: new reference in "name" or "name:tag" format
+func RunTag(source, target string) {
+	meta, err := image.TagImage(source, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Tagged %s as %s:%s\n", meta.ID[:12], meta.Name, meta.Tag)
 }
 
 // RunPull pulls an image from a registry.
-func RunPull(ref string) {
-	meta, err := image.Pull(ref)
+// Accepts "--max-concurrent-downloads N" and "--platform os/arch[/variant]"
+// before the image reference.
+func RunPull(args []string) {
+	var opts image.PullOptions
+	var ref string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--max-concurrent-downloads":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					fmt.Fprintf(os.Stderr, "error: invalid --max-concurrent-downloads: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				opts.MaxConcurrentDownloads = n
+				i++
+			}
+		case "--platform":
+			if i+1 < len(args) {
+				platform, err := image.ParsePlatform(args[i+1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					os.Exit(1)
+				}
+				opts.Platform = platform
+				i++
+			}
+		default:
+			ref = args[i]
+		}
+	}
+
+	if ref == "" {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer pull [options] <image>")
+		os.Exit(1)
+	}
+
+	meta, err := image.Pull(ref, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "pull failed: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Pulled: %s:%s (%s)\n", meta.Name, meta.Tag, meta.ID[:12])
+	fmt.Printf("Pulled: %s (%s)\n", ref, meta.ID[:12])
+}
+
+// RunPush pushes a local image to a registry.
+//
+// Parameters:
+//   - ref: image reference ("registry/name:tag"); Docker Hub if no registry is given
+func RunPush(ref string) {
+	digest, err := image.Push(ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "push failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pushed: %s (%s)\n", ref, digest)
+}
+
+// RunLogin verifies registry credentials and stores them in the auth file.
+// Usage: login [-u USER] [-p PASS | --password-stdin] [REGISTRY]
+// Missing username/password are prompted for; the registry defaults to Docker Hub.
+func RunLogin(args []string) {
+	registry := image.DefaultRegistry
+	var username, password string
+	passwordStdin := false
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-u", "--username":
+			if i+1 < len(args) {
+				username = args[i+1]
+				i++
+			}
+		case "-p", "--password":
+			if i+1 < len(args) {
+				password = args[i+1]
+				i++
+			}
+		case "--password-stdin":
+			passwordStdin = true
+		default:
+			registry = normalizeRegistry(args[i])
+		}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	if passwordStdin {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintf(os.Stderr, "error: read password from stdin: %v\n", err)
+			os.Exit(1)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if username == "" {
+		fmt.Print("Username: ")
+		line, _ := reader.ReadString('\n')
+		username = strings.TrimSpace(line)
+	}
+	if password == "" && !passwordStdin {
+		fmt.Print("Password: ")
+		var err error
+		password, err = readPassword()
+		fmt.Println()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: read password: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if username == "" || password == "" {
+		fmt.Fprintln(os.Stderr, "error: username and password are required")
+		os.Exit(1)
+	}
+
+	// Verify the credentials before saving them
+	client, err := image.NewRegistryClient(image.ImageReference{Registry: registry})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	client.SetCredentials(username, password)
+	if err := client.Authenticate(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := image.StoreCredentials(registry, username, password); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Login Succeeded (credentials saved to %s)\n", image.AuthConfigPath())
+}
+
+// RunLogout removes stored credentials for a registry (default: Docker Hub).
+func RunLogout(args []string) {
+	registry := image.DefaultRegistry
+	if len(args) > 0 {
+		registry = normalizeRegistry(args[0])
+	}
+
+	removed, err := image.RemoveCredentials(registry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if !removed {
+		fmt.Printf("Not logged in to %s\n", registry)
+		return
+	}
+	fmt.Printf("Removing login credentials for %s\n", registry)
+}
+
+// normalizeRegistry strips a URL scheme and path from a registry argument
+// and maps Docker Hub aliases to its registry endpoint.
+// Example: "https://ghcr.io/" -> "ghcr.io", "docker.io" -> "registry-1.docker.io"
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+	if registry == "docker.io" || registry == "index.docker.io" {
+		return image.DefaultRegistry
+	}
+	return registry
+}
+
+// readPassword reads a line from the terminal with echo disabled.
+// Falls back to a plain read when stdin is not a terminal.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	oldState, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err == nil {
+		noEcho := *oldState
+		noEcho.Lflag &^= unix.ECHO
+		if err := unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+			return "", err
+		}
+		defer unix.IoctlSetTermios(fd, unix.TCSETS, oldState)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
 // RunLogs displays the logs from a container.
@@ -307,8 +795,33 @@ func RunInspect(idOrName string) {
 		},
 		"Config": map[string]any{
 			"Cmd":    cs.Command,
+			"Image":  cs.Image,
 			"Rootfs": cs.RootfsPath,
 		},
+		"Image":  cs.ImageID,
+		"Layers": cs.Layers,
+	}
+
+	// Pretty-print JSON
+	output, err := json.MarshalIndent(inspection, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(string(output))
+}
+
+// RunImageInspect prints the details of a local image as JSON:
+// metadata, tags, repo digests, the image config and per-layer sizes.
+//
+// Parameters:
+//   - ref: image reference ("name:tag" or "name@sha256:...") or image ID
+func RunImageInspect(ref string) {
+	inspection, err := image.InspectImage(ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
 
 	// Pretty-print JSON
@@ -321,6 +834,188 @@ func RunInspect(idOrName string) {
 	fmt.Println(string(output))
 }
 
+// RunImageHistory lists the steps that built an image, newest first.
+// Displays layer (short digest), creation time, command, size and comment.
+//
+// Parameters:
+//   - ref: image reference ("name:tag" or "name@sha256:...") or image ID
+func RunImageHistory(ref string) {
+	items, err := image.ImageHistory(ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Print header
+	fmt.Printf("%-12s  %-16s  %-45s  %-10s  %s\n",
+		"LAYER", "CREATED", "CREATED BY", "SIZE", "COMMENT")
+
+	// Print each step
+	for _, item := range items {
+		layer := "<none>" // Config-only step
+		if item.Layer != "" {
+			layer = strings.TrimPrefix(item.Layer, "sha256:")[:12]
+		}
+		created := "-"
+		if item.Created != nil {
+			created = formatTimeAgo(*item.Created)
+		}
+		createdBy := item.CreatedBy
+		if len(createdBy) > 45 {
+			createdBy = createdBy[:42] + "..."
+		}
+		fmt.Printf("%-12s  %-16s  %-45s  %-10s  %s\n",
+			layer,
+			created,
+			createdBy,
+			formatSize(item.Size),
+			item.Comment,
+		)
+	}
+}
+
+// RunImageSign signs the manifest digest of a local image with an ed25519 key.
+// Usage: image sign --key FILE [-o FILE|DIR] <image>
+// Without -o the signature is pushed to the image's registry as an artifact.
+func RunImageSign(args []string) {
+	var ref string
+	var opts image.SignOptions
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--key":
+			if i+1 < len(args) {
+				opts.KeyPath = args[i+1]
+				i++
+			}
+		case "-o", "--output":
+			if i+1 < len(args) {
+				opts.Output = args[i+1]
+				i++
+			}
+		default:
+			ref = args[i]
+		}
+	}
+
+	if ref == "" || opts.KeyPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: minicontainer image sign --key <file> [-o <file|dir>] <image>")
+		os.Exit(1)
+	}
+
+	sig, location, err := image.Sign(ref, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sign failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signed %s with key %s\n", sig.Digest, sig.KeyID)
+	fmt.Printf("Signature: %s\n", location)
+}
+
+// RunImagePrune removes unused images and garbage-collects snapshots and blobs.
+// Usage: image prune [-a|--all] [--filter until=<timestamp|duration>]
+// Layers used by containers (running or stopped) are never removed.
+func RunImagePrune(args []string) {
+	var opts image.PruneOptions
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-a", "--all":
+			opts.All = true
+		case "--filter":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "error: --filter requires a value")
+				os.Exit(1)
+			}
+			i++
+			value, ok := strings.CutPrefix(args[i], "until=")
+			if !ok {
+				fmt.Fprintf(os.Stderr, "error: unsupported filter %q (only until=)\n", args[i])
+				os.Exit(1)
+			}
+			until, err := parseUntil(value, time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			opts.Until = until
+		default:
+			fmt.Fprintf(os.Stderr, "error: unknown option %s\n", args[i])
+			os.Exit(1)
+		}
+	}
+
+	// Containers pin their images and the layers their overlays are built on
+	containers, err := containerImages()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Containers = containers
+
+	report, err := image.Prune(opts)
+	if report != nil {
+		for _, tag := range report.Untagged {
+			fmt.Printf("Untagged: %s\n", tag)
+		}
+		for _, id := range report.DeletedImages {
+			fmt.Printf("Deleted: sha256:%s\n", id)
+		}
+		for _, digest := range report.DeletedLayers {
+			fmt.Printf("Deleted layer: %s\n", digest)
+		}
+		for _, digest := range report.DeletedBlobs {
+			fmt.Printf("Deleted blob: %s\n", digest)
+		}
+		for _, path := range report.TempFiles {
+			fmt.Printf("Removed: %s\n", path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "prune failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Total reclaimed space: %s\n", formatSize(report.ReclaimedBytes))
+}
+
+// containerImages returns the image and layers of every container, running or
+// stopped, for image removal to leave alone.
+func containerImages() ([]image.ContainerImage, error) {
+	containers, err := state.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []image.ContainerImage
+	for _, c := range containers {
+		ci := image.ContainerImage{Container: c.Name, ImageID: c.ImageID, LayerDirs: c.LowerDirs}
+		// The overlay is recorded once it is set up; until then the layers are known by diffID
+		if len(ci.LayerDirs) == 0 {
+			for _, diffID := range c.Layers {
+				ci.LayerDirs = append(ci.LayerDirs, image.SnapshotDir(diffID))
+			}
+		}
+		images = append(images, ci)
+	}
+	return images, nil
+}
+
+// parseUntil parses the value of an until= filter: a duration before now
+// ("24h", "90m"), an RFC 3339 timestamp, a date ("2006-01-02") or Unix seconds.
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid until value %q: want a duration, timestamp or date", value)
+}
+
 // formatSize converts bytes to human-readable format (e.g., "3.2 MB").
 func formatSize(bytes int64) string {
 	const (
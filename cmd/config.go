@@ -1,12 +1,21 @@
 package cmd
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hwang-fu/minicontainer/image"
+)
 
 // ContainerConfig holds the configuration options for a container.
 // These are parsed from CLI flags in the run command and passed
 // to the init process via environment variables.
 type ContainerConfig struct {
 	RootfsPath   string   // Path to container's root filesystem
+	LayerPaths   []string // Image layer directories, bottom to top (set when running an image)
+	Image        string   // Image reference "name:tag" the container runs (empty with --rootfs)
+	ImageID      string   // Full ID of that image (empty with --rootfs)
+	ImageLayers  []string // Layer diffIDs of that image, bottom to top
 	Hostname     string   // Custom hostname for the container
 	Name         string   // Container name (for identification in ps, stop, etc.)
 	Env          []string // User-specified environment variables (KEY=VALUE format)
@@ -19,6 +28,8 @@ type ContainerConfig struct {
 	CPULimit     string   // CPU limit (e.g., "0.5", "2")
 	PidsLimit    int      // Max number of processes (--pids-limit)
 	PortMappings []string // Port mappings in "hostPort:containerPort" format
+	WorkingDir   string   // Working directory for the container process (from image config)
+	User         string   // User to run as: "user", "uid", "user:group" or "uid:gid" (from image config)
 }
 
 // ParseRunFlags parses command-line flags for the run command.
@@ -124,3 +135,46 @@ func ParseRunFlags(args []string) (ContainerConfig, []string) {
 	}
 	return cfg, []string{}
 }
+
+// applyImageConfig merges an image's runtime config into the container config.
+// Follows Docker semantics:
+//   - Entrypoint is always prepended; user arguments replace Cmd
+//   - Image Env is applied first, so -e values override it
+//   - WorkingDir and User are taken from the image
+//
+// Returns the command to run inside the container.
+func applyImageConfig(cfg *ContainerConfig, imgCfg *image.ImageConfig, cmdArgs []string) []string {
+	cfg.Env = mergeEnv(imgCfg.Config.Env, cfg.Env)
+	cfg.WorkingDir = imgCfg.Config.WorkingDir
+	cfg.User = imgCfg.Config.User
+
+	command := append([]string{}, imgCfg.Config.Entrypoint...)
+	if len(cmdArgs) > 0 {
+		return append(command, cmdArgs...)
+	}
+	return append(command, imgCfg.Config.Cmd...)
+}
+
+// mergeEnv combines two KEY=VALUE lists.
+// Entries in overrides replace entries in base with the same key;
+// the order of base is preserved and new keys are appended.
+// Example: mergeEnv(["A=1", "B=2"], ["B=3", "C=4"]) -> ["A=1", "B=3", "C=4"]
+func mergeEnv(base, overrides []string) []string {
+	merged := append([]string{}, base...)
+	index := make(map[string]int, len(merged))
+	for i, e := range merged {
+		key, _, _ := strings.Cut(e, "=")
+		index[key] = i
+	}
+
+	for _, e := range overrides {
+		key, _, _ := strings.Cut(e, "=")
+		if i, ok := index[key]; ok {
+			merged[i] = e
+		} else {
+			index[key] = len(merged)
+			merged = append(merged, e)
+		}
+	}
+	return merged
+}
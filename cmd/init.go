@@ -45,14 +45,46 @@ func RunInit(args []string) {
 		}
 	}
 
-	// Find and exec the command
+	// Change to the working directory (created if missing, like Docker)
+	if workdir := os.Getenv("MINICONTAINER_WORKDIR"); workdir != "" {
+		if err := os.MkdirAll(workdir, 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "create working directory failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := syscall.Chdir(workdir); err != nil {
+			fmt.Fprintf(os.Stderr, "chdir to working directory failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	env := buildContainerEnv()
+
+	// Drop privileges to the configured user
+	if userSpec := os.Getenv("MINICONTAINER_USER"); userSpec != "" {
+		user, err := lookupUser(userSpec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if err := switchUser(user); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		env = mergeEnv([]string{"HOME=" + user.Home}, env)
+	}
+
+	// Find and exec the command, searching the container's PATH
+	for _, e := range env {
+		if val, ok := strings.CutPrefix(e, "PATH="); ok {
+			os.Setenv("PATH", val)
+		}
+	}
 	path, err := exec.LookPath(args[0])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "command not found: %s\n", args[0])
 		os.Exit(1)
 	}
 
-	env := buildContainerEnv()
 	if err := syscall.Exec(path, args, env); err != nil {
 		fmt.Fprintf(os.Stderr, "exec failed: %v\n", err)
 		os.Exit(1)
@@ -113,15 +145,17 @@ func setupRootfs(rootfsPath string) error {
 }
 
 // buildContainerEnv builds the environment for the container process.
+// User and image variables override the defaults (e.g., an image's PATH).
 func buildContainerEnv() []string {
-	env := []string{
+	defaults := []string{
 		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
 		"TERM=xterm",
 	}
+	var env []string
 	for _, e := range os.Environ() {
 		if strings.HasPrefix(e, "MINICONTAINER_ENV_") {
 			env = append(env, strings.TrimPrefix(e, "MINICONTAINER_ENV_"))
 		}
 	}
-	return env
+	return mergeEnv(defaults, env)
 }
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// containerUser is a user resolved from the container's /etc/passwd and /etc/group.
+type containerUser struct {
+	UID    int    // Numeric user ID
+	GID    int    // Primary group ID
+	Groups []int  // Supplementary group IDs
+	Home   string // Home directory (defaults to "/")
+}
+
+// lookupUser resolves a Docker-style user spec inside the container.
+// Must be called after pivot_root so /etc/passwd is the container's.
+//
+// Supported formats:
+//   - "nginx"        -> uid/gid of nginx from /etc/passwd
+//   - "1000"         -> uid 1000 (gid from /etc/passwd, or 0 if unknown)
+//   - "nginx:staff"  -> uid of nginx, gid of group staff
+//   - "1000:1000"    -> uid 1000, gid 1000
+func lookupUser(spec string) (*containerUser, error) {
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+	user := &containerUser{Home: "/"}
+
+	// Resolve the user: numeric IDs don't need to exist in /etc/passwd
+	uid, numeric := parseID(userPart)
+	entry, found := findEntry("/etc/passwd", func(fields []string) bool {
+		if numeric {
+			return len(fields) > 2 && fields[2] == userPart
+		}
+		return fields[0] == userPart
+	})
+	switch {
+	case found && len(entry) >= 6:
+		user.UID, _ = strconv.Atoi(entry[2])
+		user.GID, _ = strconv.Atoi(entry[3])
+		user.Home = entry[5]
+	case numeric:
+		user.UID = uid
+	default:
+		return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+	}
+
+	// Resolve an explicit group, overriding the passwd primary group
+	if hasGroup {
+		gid, gidNumeric := parseID(groupPart)
+		groupEntry, groupFound := findEntry("/etc/group", func(fields []string) bool {
+			if gidNumeric {
+				return len(fields) > 2 && fields[2] == groupPart
+			}
+			return fields[0] == groupPart
+		})
+		switch {
+		case groupFound && len(groupEntry) >= 3:
+			user.GID, _ = strconv.Atoi(groupEntry[2])
+		case gidNumeric:
+			user.GID = gid
+		default:
+			return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+		}
+	}
+
+	// Collect supplementary groups listing this user as a member
+	if found {
+		user.Groups = supplementaryGroups(entry[0])
+	}
+
+	return user, nil
+}
+
+// switchUser drops root privileges to the given user.
+// Groups must be set before the uid, since setgid requires privileges.
+func switchUser(user *containerUser) error {
+	groups := append([]int{user.GID}, user.Groups...)
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups failed: %w", err)
+	}
+	if err := syscall.Setgid(user.GID); err != nil {
+		return fmt.Errorf("setgid %d failed: %w", user.GID, err)
+	}
+	if err := syscall.Setuid(user.UID); err != nil {
+		return fmt.Errorf("setuid %d failed: %w", user.UID, err)
+	}
+	return nil
+}
+
+// supplementaryGroups returns the IDs of all groups listing name as a member.
+func supplementaryGroups(name string) []int {
+	var groups []int
+	forEachEntry("/etc/group", func(fields []string) bool {
+		if len(fields) < 4 {
+			return false
+		}
+		for member := range strings.SplitSeq(fields[3], ",") {
+			if member == name {
+				if gid, ok := parseID(fields[2]); ok {
+					groups = append(groups, gid)
+				}
+				break
+			}
+		}
+		return false
+	})
+	return groups
+}
+
+// findEntry returns the fields of the first line in a colon-separated
+// database file (passwd/group format) that satisfies match.
+func findEntry(path string, match func(fields []string) bool) ([]string, bool) {
+	var result []string
+	forEachEntry(path, func(fields []string) bool {
+		if match(fields) {
+			result = fields
+			return true
+		}
+		return false
+	})
+	return result, result != nil
+}
+
+// forEachEntry calls fn with the fields of each line in a passwd/group style file.
+// Iteration stops when fn returns true. A missing file yields no entries.
+func forEachEntry(path string, fn func(fields []string) bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fn(strings.Split(line, ":")) {
+			return
+		}
+	}
+}
+
+// parseID parses a non-negative numeric uid or gid.
+func parseID(s string) (int, bool) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/hwang-fu/minicontainer/cmd"
 	"github.com/hwang-fu/minicontainer/network"
 	"github.com/hwang-fu/minicontainer/runtime"
+	"github.com/hwang-fu/minicontainer/state"
 )
 
 // RunWithTTY runs the container with pseudo-terminal for interactive mode.
@@ -139,6 +140,58 @@ func RunWithoutTTY(cfg cmd.ContainerConfig, cmdArgs []string) {
 	cr.Cleanup()
 }
 
+// RunToCompletion runs a container and waits for it to exit, returning errors
+// instead of exiting. Used by build to execute RUN steps: the container is left
+// stopped so its upper directory can become a layer, and the caller removes it.
+//
+// Parameters:
+//   - cfg: container config (LayerPaths set to the layers to run on)
+//   - cmdArgs: command and arguments to run
+//   - stdout, stderr: destinations for the container output
+//
+// Returns:
+//   - *state.ContainerState: state of the stopped container (ExitCode holds the exit status)
+//   - error: if the container could not be started
+func RunToCompletion(cfg cmd.ContainerConfig, cmdArgs []string, stdout, stderr io.Writer) (*state.ContainerState, error) {
+	cr, err := NewContainerRuntime(cfg, cmdArgs)
+	if err != nil {
+		return nil, fmt.Errorf("initialize container: %w", err)
+	}
+
+	execCmd := cr.BuildCommand(false)
+	execCmd.Stdout = io.MultiWriter(stdout, NewTimestampedLogWriter(cr.LogFile, "stdout"))
+	execCmd.Stderr = io.MultiWriter(stderr, NewTimestampedLogWriter(cr.LogFile, "stderr"))
+
+	if err := execCmd.Start(); err != nil {
+		cr.Cleanup()
+		return cr.State, err
+	}
+
+	if err := cr.AddToCgroup(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to add to cgroup: %v\n", err)
+	}
+
+	// Move veth into container's network namespace
+	if err := network.MoveVethToNetns(cr.VethContainer, cr.Cmd.Process.Pid); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to move veth: %v\n", err)
+	}
+
+	// Setup container network (rename veth, assign IP, routes)
+	if err := network.SetupContainerNetwork(cr.Cmd.Process.Pid, cr.VethContainer, cr.ContainerIP); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to setup network: %v\n", err)
+	}
+
+	cr.MarkRunning()
+
+	execCmd.Wait()
+	cr.MarkStopped()
+	cr.Cleanup()
+	cr.LogFile.Close()
+	network.ReleaseIP(cr.ContainerIP)
+
+	return cr.State, nil
+}
+
 // RunDetached runs container in background, returns immediately.
 func RunDetached(cfg cmd.ContainerConfig, cmdArgs []string) {
 	cr, err := NewContainerRuntime(cfg, cmdArgs)
@@ -197,6 +250,12 @@ func BuildEnv(cfg cmd.ContainerConfig) []string {
 	for _, e := range cfg.Env {
 		env = append(env, "MINICONTAINER_ENV_"+e)
 	}
+	if cfg.WorkingDir != "" {
+		env = append(env, "MINICONTAINER_WORKDIR="+cfg.WorkingDir)
+	}
+	if cfg.User != "" {
+		env = append(env, "MINICONTAINER_USER="+cfg.User)
+	}
 	for i, v := range cfg.Volumes {
 		env = append(env, fmt.Sprintf("MINICONTAINER_VOLUME_%d=%s", i, v))
 	}
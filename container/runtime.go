@@ -36,11 +36,6 @@ type ContainerRuntime struct {
 // This is the common setup shared by all run modes.
 // Returns error if any step fails; caller should handle cleanup.
 func NewContainerRuntime(cfg cmd.ContainerConfig, cmdArgs []string) (*ContainerRuntime, error) {
-	// Prepare rootfs directories before namespace entry (avoids permission issues)
-	if err := prepareRootfs(cfg.RootfsPath); err != nil {
-		return nil, fmt.Errorf("prepare rootfs: %w", err)
-	}
-
 	// Generate unique 64-char hex ID using SHA256 of random bytes
 	containerID, err := GenerateContainerID()
 	if err != nil {
@@ -55,6 +50,9 @@ func NewContainerRuntime(cfg cmd.ContainerConfig, cmdArgs []string) (*ContainerR
 
 	// Create initial state with status=created and save to disk
 	containerState := state.NewContainerState(containerID, containerName, cfg.RootfsPath, cmdArgs)
+	containerState.Image = cfg.Image
+	containerState.ImageID = cfg.ImageID
+	containerState.Layers = cfg.ImageLayers
 	if err = state.SaveState(containerState); err != nil {
 		return nil, fmt.Errorf("save state: %w", err)
 	}
@@ -105,14 +103,35 @@ func NewContainerRuntime(cfg cmd.ContainerConfig, cmdArgs []string) (*ContainerR
 	}
 	cr.CgroupPath = cgroupPath
 
-	// Setup overlayfs: lower=rootfs (read-only), upper=writable layer, merged=container view
+	// Setup overlayfs: lower=rootfs or image layers (read-only), upper=writable layer,
+	// merged=container view
+	lowerDirs := cfg.LayerPaths
 	if cfg.RootfsPath != "" {
-		overlay, cleanup, err := fs.SetupOverlayfs(cfg.RootfsPath)
+		lowerDirs = []string{cfg.RootfsPath}
+	}
+	if len(lowerDirs) > 0 {
+		overlay, cleanup, err := fs.SetupOverlayfs(state.OverlayDir(containerID), lowerDirs)
 		if err != nil {
 			return nil, fmt.Errorf("setup overlay: %w", err)
 		}
 		cr.OverlayCleanup = cleanup
 		cr.ActualRootfs = overlay.MergedDir
+
+		// Record the overlay so export/commit can find the container's changes
+		containerState.LowerDirs = overlay.LowerDirs
+		containerState.UpperDir = overlay.UpperDir
+		containerState.MergedDir = overlay.MergedDir
+		if err := state.SaveState(containerState); err != nil {
+			cr.Cleanup()
+			return nil, fmt.Errorf("save state: %w", err)
+		}
+	}
+
+	// Prepare rootfs directories before namespace entry (avoids permission issues).
+	// Done on the merged view so the read-only lower layers are never modified.
+	if err := prepareRootfs(cr.ActualRootfs); err != nil {
+		cr.Cleanup()
+		return nil, fmt.Errorf("prepare rootfs: %w", err)
 	}
 
 	// Bind mount volumes into container rootfs (must happen before pivot_root)
@@ -4,33 +4,39 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 )
 
 // OverlayMount holds paths for an overlayfs mount.
 // Used to track the mount for cleanup.
 type OverlayMount struct {
-	LowerDir  string // Base filesystem (read-only)
-	UpperDir  string // Changes layer (writable)
-	WorkDir   string // Overlayfs internal (must be empty)
-	MergedDir string // Unified view (container sees this)
-	BaseDir   string // Parent directory containing upper/work/merged
+	LowerDirs []string // Base filesystem layers, bottom to top (read-only)
+	UpperDir  string   // Changes layer (writable)
+	WorkDir   string   // Overlayfs internal (must be empty)
+	MergedDir string   // Unified view (container sees this)
+	BaseDir   string   // Parent directory containing upper/work/merged
 }
 
-// SetupOverlayfs creates an overlayfs mount with the given lowerDir as the base.
+// SetupOverlayfs creates an overlayfs mount in baseDir stacking the given lowerDirs as the base.
+// lowerDirs are ordered bottom to top, the same order as image layers
+// (ImageMetadata.Layers), so the last entry takes precedence.
 // Returns an OverlayMount struct with all paths and a cleanup function.
-// The cleanup function unmounts and removes temporary directories.
+// The cleanup function unmounts the overlay and removes the work and merged
+// directories. The upper directory is kept, so the container's changes
+// outlive it (for export and commit) until the container is removed.
 //
 // Usage:
 //
-//	overlay, cleanup, err := SetupOverlayfs("/path/to/rootfs")
+//	overlay, cleanup, err := SetupOverlayfs("/var/lib/minicontainer/containers/<id>/overlay",
+//		[]string{"/path/to/base", "/path/to/top"})
 //	if err != nil { ... }
 //	defer cleanup()
 //	// Use overlay.MergedDir as the container's rootfs
-func SetupOverlayfs(lowerDir string) (*OverlayMount, func() error, error) {
-	baseDir, err := os.MkdirTemp("/tmp", "minicontainer-overlay-")
-	if err != nil {
-		return nil, nil, fmt.Errorf("create overlay base dir: %w", err)
+func SetupOverlayfs(baseDir string, lowerDirs []string) (*OverlayMount, func() error, error) {
+	if len(lowerDirs) == 0 {
+		return nil, nil, fmt.Errorf("overlay requires at least one lower directory")
 	}
 
 	// Create subdirectories
@@ -46,28 +52,30 @@ func SetupOverlayfs(lowerDir string) (*OverlayMount, func() error, error) {
 	}
 
 	// Mount overlayfs
-	if err := mountOverlay(lowerDir, upperDir, workDir, mergedDir); err != nil {
+	if err := mountOverlay(lowerDirs, upperDir, workDir, mergedDir); err != nil {
 		os.RemoveAll(baseDir) // Cleanup on failure
 		return nil, nil, err
 	}
 
 	overlay := &OverlayMount{
-		LowerDir:  lowerDir,
+		LowerDirs: lowerDirs,
 		UpperDir:  upperDir,
 		WorkDir:   workDir,
 		MergedDir: mergedDir,
 		BaseDir:   baseDir,
 	}
 
-	// Cleanup function: unmount and remove directories
+	// Cleanup function: unmount and remove everything but the upper directory
 	cleanup := func() error {
 		// Unmount the overlay
 		if err := syscall.Unmount(mergedDir, 0); err != nil {
 			return fmt.Errorf("unmount overlay: %w", err)
 		}
-		// Remove all temporary directories
-		if err := os.RemoveAll(baseDir); err != nil {
-			return fmt.Errorf("remove overlay dirs: %w", err)
+		// Remove overlay internals; upper holds the container's changes
+		for _, dir := range []string{workDir, mergedDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("remove overlay dirs: %w", err)
+			}
 		}
 		return nil
 	}
@@ -75,11 +83,65 @@ func SetupOverlayfs(lowerDir string) (*OverlayMount, func() error, error) {
 	return overlay, cleanup, nil
 }
 
+// MountReadOnlyView mounts a read-only overlay of a stopped container's
+// filesystem: its upper directory stacked on top of its lower directories.
+// Whiteouts in upperDir hide deleted files just as they did while it ran.
+// Returns the mount point and a function that unmounts and removes it.
+func MountReadOnlyView(upperDir string, lowerDirs []string) (string, func() error, error) {
+	mountPoint, err := os.MkdirTemp("", "minicontainer-view-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create mount point: %w", err)
+	}
+
+	// Without upperdir/workdir overlayfs mounts read-only; upper becomes the top lower
+	topDown := slices.Clone(lowerDirs)
+	slices.Reverse(topDown)
+	opts := "lowerdir=" + strings.Join(append([]string{upperDir}, topDown...), ":")
+	if err := checkOverlayOptions(opts, len(lowerDirs)+1); err != nil {
+		os.Remove(mountPoint)
+		return "", nil, err
+	}
+	if err := syscall.Mount("overlay", mountPoint, "overlay", syscall.MS_RDONLY, opts); err != nil {
+		os.Remove(mountPoint)
+		return "", nil, fmt.Errorf("mount overlay: %w", err)
+	}
+
+	cleanup := func() error {
+		if err := syscall.Unmount(mountPoint, 0); err != nil {
+			return fmt.Errorf("unmount overlay: %w", err)
+		}
+		return os.Remove(mountPoint)
+	}
+	return mountPoint, cleanup, nil
+}
+
+// IsMounted reports whether path is currently a mount point.
+func IsMounted(path string) bool {
+	return getMountedPaths()[path]
+}
+
+// UnmountIfMounted detaches a leftover overlay mount, e.g. from a detached
+// container that exited without cleaning up. Safe to call on any path.
+func UnmountIfMounted(path string) {
+	if path != "" && IsMounted(path) {
+		syscall.Unmount(path, syscall.MNT_DETACH)
+	}
+}
+
 // mountOverlay performs the actual overlayfs mount syscall.
-func mountOverlay(lower, upper, work, merged string) error {
+// lowers is ordered bottom to top; overlayfs expects the topmost layer first.
+func mountOverlay(lowers []string, upper, work, merged string) error {
+	// Overlayfs lists lower layers top to bottom: "lowerdir=top:middle:base"
+	topDown := slices.Clone(lowers)
+	slices.Reverse(topDown)
+
 	// Build mount options string
-	// Format: "lowerdir=<lower>,upperdir=<upper>,workdir=<work>"
-	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	// Format: "lowerdir=<top>:...:<base>,upperdir=<upper>,workdir=<work>"
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(topDown, ":"), upper, work)
+	if err := checkOverlayOptions(opts, len(lowers)); err != nil {
+		return err
+	}
 
 	// Mount overlayfs
 	// - source: "overlay" (conventional name, not a real device)
@@ -92,3 +154,15 @@ func mountOverlay(lower, upper, work, merged string) error {
 	}
 	return nil
 }
+
+// checkOverlayOptions fails with a clear error if the mount options do not
+// fit in the single page the kernel copies mount data into (including the
+// terminating NUL); mount(2) would only report EINVAL. Every lower directory
+// adds its full path, so images with many layers run into this limit.
+func checkOverlayOptions(opts string, layers int) error {
+	if limit := os.Getpagesize() - 1; len(opts) > limit {
+		return fmt.Errorf("mount overlay: %d layers need %d bytes of mount options, more than the kernel limit of %d; "+
+			"squash the image into fewer layers", layers, len(opts), limit)
+	}
+	return nil
+}
@@ -2,4 +2,7 @@ module github.com/hwang-fu/minicontainer
 
 go 1.25.5
 
-require golang.org/x/sys v0.39.0 // indirect
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/sys v0.39.0
+)
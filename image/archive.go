@@ -0,0 +1,336 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// inode identifies a file for hardlink detection.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// writeLayerTar writes the contents of srcDir to w as an image layer tar stream.
+// It is the inverse of extractLayerTar: overlayfs whiteouts are converted back
+// into their OCI representation:
+//   - character device 0/0 named <name>          -> ".wh.<name>"
+//   - "trusted.overlay.opaque=y" on a directory  -> "<dir>/.wh..wh..opq"
+//
+// Preserves ownership, permissions, modification times, hardlinks, symlinks,
+// device nodes, FIFOs and extended attributes. Entries are written in lexical
+// order so the same directory always produces the same stream.
+//
+// Parameters:
+//   - w: destination for the uncompressed tar stream
+//   - srcDir: layer directory to archive
+//
+// Returns:
+//   - error: any error while reading srcDir or writing the stream
+func writeLayerTar(w io.Writer, srcDir string) error {
+	return writeTree(w, srcDir, false)
+}
+
+// ExportTree writes a container's merged root filesystem to w as a flat tar
+// that `import` accepts. Only the root's own filesystem is archived, so bind
+// mounted volumes are left out, as is the runtime's .pivot_root directory.
+//
+// Parameters:
+//   - w: destination for the tar stream
+//   - rootDir: merged (or read-only overlay) view of the container filesystem
+//
+// Returns:
+//   - error: any error while reading rootDir or writing the stream
+func ExportTree(w io.Writer, rootDir string) error {
+	return writeTree(w, rootDir, true)
+}
+
+// writeContainerLayer writes a container's overlay upper directory to w as an
+// image layer tar stream, leaving out the runtime's .pivot_root directory.
+func writeContainerLayer(w io.Writer, upperDir string) error {
+	return writeTree(w, upperDir, true)
+}
+
+// writeTree implements writeLayerTar, writeContainerLayer and ExportTree.
+// With rootfsOnly set, directories on other filesystems (mount points) and
+// the .pivot_root directory created by the runtime are skipped.
+func writeTree(w io.Writer, srcDir string, rootfsOnly bool) error {
+	tw := tar.NewWriter(w)
+	links := make(map[inode]string) // First path seen for each multiply-linked file
+
+	rootInfo, err := os.Stat(srcDir)
+	if err != nil {
+		return err
+	}
+	rootStat, _ := rootInfo.Sys().(*syscall.Stat_t)
+
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil // The layer root itself is implied
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		stat, _ := info.Sys().(*syscall.Stat_t)
+
+		if rootfsOnly && info.IsDir() {
+			if rel == ".pivot_root" || (stat != nil && rootStat != nil && stat.Dev != rootStat.Dev) {
+				return filepath.SkipDir
+			}
+		}
+
+		// Step 1: Overlay whiteouts become ".wh." entries
+		if info.Mode()&os.ModeCharDevice != 0 && stat != nil && stat.Rdev == 0 {
+			return writeWhiteout(tw, filepath.Join(filepath.Dir(rel), whiteoutPrefix+filepath.Base(rel)), info)
+		}
+
+		// Step 2: Build the header from the file info
+		var linkTarget string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+			return fmt.Errorf("header for %s: %w", rel, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", "" // Numeric IDs only; names depend on the host
+		// Access and change times vary between reads; leave them out so
+		// archiving the same directory twice yields the same digest
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+
+		// Step 3: Record hardlinks to a file already written
+		if stat != nil && info.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inode{uint64(stat.Dev), stat.Ino}
+			if first, ok := links[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[key] = hdr.Name
+			}
+		}
+
+		// Step 4: Extended attributes (overlay-private ones are not layer content)
+		xattrs, opaque, err := readXattrs(path)
+		if err != nil {
+			return fmt.Errorf("read xattrs of %s: %w", rel, err)
+		}
+		for attr, value := range xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string)
+			}
+			hdr.PAXRecords[paxXattrPrefix+attr] = value
+		}
+		if len(hdr.PAXRecords) > 0 {
+			hdr.Format = tar.FormatPAX
+		}
+
+		// Step 5: Write the header and file contents
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write header for %s: %w", rel, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if err := copyFileInto(tw, path); err != nil {
+				return fmt.Errorf("write %s: %w", rel, err)
+			}
+		}
+
+		// Opaque directories hide all lower-layer contents
+		if opaque {
+			return writeWhiteout(tw, filepath.Join(rel, whiteoutOpaque), info)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// writeWhiteout writes an empty OCI whiteout entry.
+func writeWhiteout(tw *tar.Writer, name string, info os.FileInfo) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Mode:     0o644,
+		ModTime:  info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write whiteout %s: %w", name, err)
+	}
+	return nil
+}
+
+// copyFileInto streams a regular file into the tar writer.
+func copyFileInto(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// readXattrs returns the extended attributes of path, excluding overlayfs
+// internals, and whether the path is marked as an opaque overlay directory.
+func readXattrs(path string) (map[string]string, bool, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if size == 0 {
+		return nil, false, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, false, err
+	}
+
+	xattrs := make(map[string]string)
+	opaque := false
+	for _, attr := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if attr == "" {
+			continue
+		}
+
+		value, err := getXattr(path, attr)
+		if err != nil {
+			return nil, false, err
+		}
+		if attr == overlayOpaque {
+			opaque = value == "y"
+			continue
+		}
+		if strings.HasPrefix(attr, "trusted.overlay.") {
+			continue
+		}
+		xattrs[attr] = value
+	}
+	return xattrs, opaque, nil
+}
+
+// getXattr reads a single extended attribute without following symlinks.
+func getXattr(path, attr string) (string, error) {
+	size, err := unix.Lgetxattr(path, attr, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Lgetxattr(path, attr, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
+
+// compressLayer archives srcDir as a gzip-compressed layer tarball into w.
+//
+// Returns:
+//   - digest: "sha256:..." of the compressed blob (the manifest layer digest)
+//   - diffID: "sha256:..." of the uncompressed tar (the config rootfs diff_id)
+//   - size: compressed size in bytes
+//   - err: any error while archiving
+func compressLayer(srcDir string, w io.Writer) (digest, diffID string, size int64, err error) {
+	return compressTar(w, func(tw io.Writer) error {
+		return writeLayerTar(tw, srcDir)
+	})
+}
+
+// compressTar gzip-compresses the tar stream produced by writeTar into w.
+// Returns the same digests and size as compressLayer.
+func compressTar(w io.Writer, writeTar func(io.Writer) error) (digest, diffID string, size int64, err error) {
+	blobHash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, blobHash)}
+
+	gz := gzip.NewWriter(counter)
+	tarHash := sha256.New()
+	if err := writeTar(io.MultiWriter(gz, tarHash)); err != nil {
+		return "", "", 0, fmt.Errorf("archive layer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", "", 0, fmt.Errorf("compress layer: %w", err)
+	}
+
+	return fmt.Sprintf("sha256:%x", blobHash.Sum(nil)),
+		fmt.Sprintf("sha256:%x", tarHash.Sum(nil)),
+		counter.n, nil
+}
+
+// imageConfigBlob builds the serialized image config for a local image whose
+// layers were re-archived with the given diffIDs (re-archiving changes them).
+// The stored config is reused when there is one; imported images get a minimal
+// config. The platform comes from the metadata, defaulting to the host.
+func imageConfigBlob(meta *ImageMetadata, diffIDs []string) ([]byte, error) {
+	config, err := LoadConfig(meta.ID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("load image config: %w", err)
+		}
+		config = &ImageConfig{}
+	}
+
+	platform := HostPlatform()
+	if meta.Platform != "" {
+		if platform, err = ParsePlatform(meta.Platform); err != nil {
+			return nil, err
+		}
+	}
+	config.OS, config.Architecture, config.Variant = platform.OS, platform.Architecture, platform.Variant
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = diffIDs
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	return data, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,206 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// AuthConfig is the on-disk registry credential store.
+// It uses the same "auths" layout as Docker's ~/.docker/config.json, so the
+// file can be shared or copied between the two tools:
+//
+//	{"auths": {"ghcr.io": {"auth": "base64(user:password)"}}}
+type AuthConfig struct {
+	Auths map[string]AuthEntry `json:"auths"`
+}
+
+// AuthEntry holds the credentials for a single registry.
+// Docker writes only "auth"; "username"/"password" are accepted when reading.
+type AuthEntry struct {
+	Auth     string `json:"auth,omitempty"`     // base64("username:password")
+	Username string `json:"username,omitempty"` // Plain username (legacy)
+	Password string `json:"password,omitempty"` // Plain password (legacy)
+}
+
+// dockerHubAliases are the keys Docker Hub credentials may be stored under.
+// Docker itself uses the legacy v1 index URL.
+var dockerHubAliases = []string{
+	"https://index.docker.io/v1/",
+	"index.docker.io",
+	"docker.io",
+	DefaultRegistry,
+}
+
+// AuthConfigPath returns the path of the credential file for the current user.
+// Defaults to ~/.minicontainer/config.json; MINICONTAINER_AUTH_CONFIG overrides it.
+func AuthConfigPath() string {
+	if path := os.Getenv("MINICONTAINER_AUTH_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/root"
+	}
+	return filepath.Join(home, ".minicontainer", "config.json")
+}
+
+// dockerConfigPath returns the path of Docker's own config file,
+// consulted read-only when minicontainer has no credentials for a registry.
+func dockerConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// LoadAuthConfig reads a credential file. A missing file yields an empty config.
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	cfg := &AuthConfig{Auths: map[string]AuthEntry{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("read auth config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse auth config %s: %w", path, err)
+	}
+	if cfg.Auths == nil {
+		cfg.Auths = map[string]AuthEntry{}
+	}
+	return cfg, nil
+}
+
+// SaveAuthConfig writes the credential file with owner-only permissions.
+func SaveAuthConfig(path string, cfg *AuthConfig) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create auth config dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal auth config: %w", err)
+	}
+
+	// Write to a temp file and rename so a crash never leaves a truncated file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write auth config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write auth config: %w", err)
+	}
+	return nil
+}
+
+// StoreCredentials saves credentials for a registry in the user's auth file.
+func StoreCredentials(registry, username, password string) error {
+	path := AuthConfigPath()
+	cfg, err := LoadAuthConfig(path)
+	if err != nil {
+		return err
+	}
+
+	// Replace any alias entries so lookups can't find stale credentials
+	for _, key := range registryKeys(registry) {
+		delete(cfg.Auths, key)
+	}
+	cfg.Auths[authKey(registry)] = AuthEntry{
+		Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+	return SaveAuthConfig(path, cfg)
+}
+
+// RemoveCredentials deletes stored credentials for a registry.
+// Returns false if there were none.
+func RemoveCredentials(registry string) (bool, error) {
+	path := AuthConfigPath()
+	cfg, err := LoadAuthConfig(path)
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	for _, key := range registryKeys(registry) {
+		if _, ok := cfg.Auths[key]; ok {
+			delete(cfg.Auths, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return false, nil
+	}
+	return true, SaveAuthConfig(path, cfg)
+}
+
+// LookupCredentials returns the stored username and password for a registry.
+// Checks the minicontainer auth file first, then Docker's config.json.
+func LookupCredentials(registry string) (username, password string, ok bool) {
+	for _, path := range []string{AuthConfigPath(), dockerConfigPath()} {
+		if path == "" {
+			continue
+		}
+		cfg, err := LoadAuthConfig(path)
+		if err != nil {
+			continue
+		}
+		for _, key := range registryKeys(registry) {
+			entry, found := cfg.Auths[key]
+			if !found {
+				continue
+			}
+			if username, password, ok = entry.credentials(); ok {
+				return username, password, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// credentials decodes an entry into a username and password.
+func (e AuthEntry) credentials() (string, string, bool) {
+	if e.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return "", "", false
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		return username, password, ok
+	}
+	if e.Username != "" {
+		return e.Username, e.Password, true
+	}
+	return "", "", false
+}
+
+// authKey returns the key new credentials are stored under.
+// Docker Hub uses Docker's legacy key so the file stays interchangeable.
+func authKey(registry string) string {
+	if isDockerHub(registry) {
+		return dockerHubAliases[0]
+	}
+	return registry
+}
+
+// registryKeys returns every key credentials for registry may be stored under.
+func registryKeys(registry string) []string {
+	if isDockerHub(registry) {
+		return dockerHubAliases
+	}
+	return []string{registry, "https://" + registry, "http://" + registry}
+}
+
+// isDockerHub reports whether registry names Docker Hub.
+func isDockerHub(registry string) bool {
+	return slices.Contains(dockerHubAliases, registry)
+}
@@ -0,0 +1,189 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ApplyChange applies a Dockerfile-style instruction to an image config.
+// Used by `commit --change` and by `build` for instructions that only change
+// the config.
+//
+// Supported instructions:
+//   - CMD / ENTRYPOINT ["executable", "arg"] or shell form (run with /bin/sh -c)
+//   - ENV KEY=VALUE [KEY=VALUE...] or ENV KEY VALUE
+//   - WORKDIR /path (relative paths are resolved against the current WorkingDir)
+//   - USER user[:group]
+//   - EXPOSE port[/protocol]... (protocol defaults to tcp)
+//   - LABEL KEY=VALUE [KEY=VALUE...]
+//
+// Parameters:
+//   - config: image config to modify
+//   - change: instruction line, e.g. `CMD ["nginx", "-g", "daemon off;"]`
+//
+// Returns:
+//   - error: if the instruction is unsupported or malformed
+func ApplyChange(config *ImageConfig, change string) error {
+	instruction, args, _ := strings.Cut(strings.TrimSpace(change), " ")
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return fmt.Errorf("%s requires an argument", strings.ToUpper(instruction))
+	}
+
+	switch strings.ToUpper(instruction) {
+	case "CMD":
+		cmd, err := ParseCommand(args)
+		if err != nil {
+			return fmt.Errorf("CMD: %w", err)
+		}
+		config.Config.Cmd = cmd
+
+	case "ENTRYPOINT":
+		entrypoint, err := ParseCommand(args)
+		if err != nil {
+			return fmt.Errorf("ENTRYPOINT: %w", err)
+		}
+		config.Config.Entrypoint = entrypoint
+
+	case "ENV":
+		env, err := parseEnv(args)
+		if err != nil {
+			return fmt.Errorf("ENV: %w", err)
+		}
+		config.Config.Env = mergeEnvList(config.Config.Env, env)
+
+	case "WORKDIR":
+		dir := args
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", config.Config.WorkingDir, dir)
+		}
+		config.Config.WorkingDir = path.Clean(dir)
+
+	case "USER":
+		config.Config.User = args
+
+	case "EXPOSE":
+		if config.Config.ExposedPorts == nil {
+			config.Config.ExposedPorts = make(map[string]struct{})
+		}
+		for _, port := range strings.Fields(args) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.Config.ExposedPorts[port] = struct{}{}
+		}
+
+	case "LABEL":
+		labels, err := parseEnv(args)
+		if err != nil {
+			return fmt.Errorf("LABEL: %w", err)
+		}
+		if config.Config.Labels == nil {
+			config.Config.Labels = make(map[string]string)
+		}
+		for _, label := range labels {
+			key, value, _ := strings.Cut(label, "=")
+			config.Config.Labels[key] = value
+		}
+
+	default:
+		return fmt.Errorf("unsupported instruction %q (supported: CMD, ENTRYPOINT, ENV, WORKDIR, USER, EXPOSE, LABEL)", instruction)
+	}
+	return nil
+}
+
+// ParseCommand parses the exec form (JSON array) or shell form of a command.
+// Example: `echo "hi"` -> ["/bin/sh", "-c", `echo "hi"`]
+func ParseCommand(args string) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(args), &cmd); err != nil {
+			return nil, fmt.Errorf("invalid JSON array %s: %w", args, err)
+		}
+		return cmd, nil
+	}
+	return []string{"/bin/sh", "-c", args}, nil
+}
+
+// parseEnv parses the arguments of an ENV or LABEL instruction into KEY=VALUE entries.
+// Values may be double-quoted to contain spaces.
+// Examples:
+//   - `A=1 B="two words"` -> ["A=1", "B=two words"]
+//   - `PATH /usr/bin:/bin` -> ["PATH=/usr/bin:/bin"] (legacy single-pair form)
+func parseEnv(args string) ([]string, error) {
+	// Legacy form: the first word has no "=", the rest of the line is the value
+	first, rest, _ := strings.Cut(args, " ")
+	if !strings.Contains(first, "=") {
+		if strings.TrimSpace(rest) == "" {
+			return nil, fmt.Errorf("missing value for %s", first)
+		}
+		return []string{first + "=" + strings.TrimSpace(rest)}, nil
+	}
+
+	var env []string
+	for _, word := range splitWords(args) {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE, got %q", word)
+		}
+		env = append(env, key+"="+value)
+	}
+	return env, nil
+}
+
+// splitWords splits s at unquoted whitespace, removing double quotes and
+// backslash escapes.
+// Example: `A="x y" B=z` -> ["A=x y", "B=z"]
+func splitWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '"':
+			quoted, inWord = !quoted, true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// mergeEnvList sets each KEY=VALUE entry of overrides in base, replacing
+// existing entries with the same key and appending new ones.
+func mergeEnvList(base, overrides []string) []string {
+	merged := append([]string{}, base...)
+	for _, e := range overrides {
+		key, _, _ := strings.Cut(e, "=")
+		replaced := false
+		for i, existing := range merged {
+			if k, _, _ := strings.Cut(existing, "="); k == key {
+				merged[i] = e
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, e)
+		}
+	}
+	return merged
+}
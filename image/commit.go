@@ -0,0 +1,219 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CommitOptions describes a container to commit and how to adjust its config.
+type CommitOptions struct {
+	UpperDir   string   // Container overlay upper directory holding its changes
+	BaseImage  string   // Image the container was created from: its ID, or "name:tag" for older containers
+	BaseLayers []string // Layer directories the container runs on, bottom to top
+	CreatedBy  string   // Container command, recorded in the history entry
+	Changes    []string // Config changes, e.g. "CMD [\"nginx\"]", "ENV A=1", "WORKDIR /app"
+	Message    string   // Commit message recorded in the history entry
+}
+
+// Commit creates a new image from a container's changes.
+// The upper directory is archived as a new layer (overlay whiteouts become
+// ".wh." entries) and stacked on top of the base image's layers. The base
+// config is copied with the changes applied and a history entry appended.
+//
+// Parameters:
+//   - ref: reference for the new image in "name" or "name:tag" format
+//   - opts: the container and config changes to commit
+//
+// Returns:
+//   - *ImageMetadata: the created image metadata
+//   - error: any error during commit
+func Commit(ref string, opts CommitOptions) (*ImageMetadata, error) {
+	// Step 1: Load the base image and make sure the container still runs on it
+	if _, err := ParseTagReference(ref); err != nil {
+		return nil, err
+	}
+	base, err := ResolveImage(opts.BaseImage)
+	if err != nil {
+		return nil, fmt.Errorf("base image: %w", err)
+	}
+	if len(base.DiffIDs) != len(base.Layers) {
+		return nil, fmt.Errorf("base image %s has no layer diff_ids, pull or import it again", opts.BaseImage)
+	}
+	if !sameLayers(base.DiffIDs, opts.BaseLayers) {
+		return nil, fmt.Errorf("base image %s changed since the container was created", opts.BaseImage)
+	}
+
+	// Step 2: Apply the config changes before touching the image store,
+	// so an invalid --change leaves nothing behind
+	config, err := LoadConfig(base.ID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("load base config: %w", err)
+		}
+		config = &ImageConfig{}
+	}
+	for _, change := range opts.Changes {
+		if err := ApplyChange(config, change); err != nil {
+			return nil, fmt.Errorf("apply change: %w", err)
+		}
+	}
+
+	// Step 3: Archive the upper directory and add it to the image store
+	digest, diffID, size, err := CreateLayer(opts.UpperDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 4: Save the image with the new layer on top and a history entry
+	now := time.Now().UTC()
+	config.History = append(config.History, HistoryEntry{
+		Created:   &now,
+		CreatedBy: opts.CreatedBy,
+		Comment:   opts.Message,
+	})
+	return StoreImage(ref, LocalImage{
+		Layers:   append(append([]string{}, base.Layers...), digest),
+		DiffIDs:  append(append([]string{}, base.DiffIDs...), diffID),
+		Size:     base.Size + size,
+		Platform: base.Platform,
+		Config:   config,
+	})
+}
+
+// LocalImage is an image assembled on this host by commit or build.
+type LocalImage struct {
+	Layers   []string     // Layer digests, bottom to top
+	DiffIDs  []string     // Layer diffIDs, bottom to top
+	Size     int64        // Total extracted size of the layers
+	Platform string       // "os/arch[/variant]" (empty for the host platform)
+	Config   *ImageConfig // Runtime config and history; rootfs and platform are filled in
+}
+
+// StoreImage saves a locally assembled image under ref.
+// The config's platform, creation time and rootfs.diff_ids are set from img,
+// and the image ID is the digest of the resulting config.
+//
+// Parameters:
+//   - ref: image reference in "name" or "name:tag" format
+//   - img: layers and config of the image
+//
+// Returns:
+//   - *ImageMetadata: the stored image metadata
+//   - error: any error while saving
+func StoreImage(ref string, img LocalImage) (*ImageMetadata, error) {
+	parsed, err := ParseTagReference(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Complete the config
+	platform := HostPlatform()
+	if img.Platform != "" {
+		if platform, err = ParsePlatform(img.Platform); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().UTC()
+	config := img.Config
+	config.OS, config.Architecture, config.Variant = platform.OS, platform.Architecture, platform.Variant
+	config.Created = &now
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = img.DiffIDs
+
+	configBlob, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	configDigest := digestBytes(configBlob)
+
+	// Step 2: Save metadata and config under the reference
+	meta := &ImageMetadata{
+		ID:           strings.TrimPrefix(configDigest, "sha256:"),
+		Name:         parsed.Name(),
+		Tag:          parsed.Tag,
+		Layers:       img.Layers,
+		DiffIDs:      img.DiffIDs,
+		ConfigDigest: configDigest,
+		Platform:     platform.String(),
+		CreatedAt:    now,
+		Size:         img.Size,
+	}
+	// The config goes first: saving the metadata creates the tag.
+	// The exact blob the ID was computed from is kept, so push and save
+	// reproduce the image ID instead of re-marshaling the config
+	if _, err := writeContent(configBlob); err != nil {
+		return nil, err
+	}
+	if err := SaveConfig(meta.ID, config); err != nil {
+		return nil, fmt.Errorf("save config: %w", err)
+	}
+	if err := SaveMetadata(meta); err != nil {
+		return nil, fmt.Errorf("save metadata: %w", err)
+	}
+
+	return meta, nil
+}
+
+// CreateLayer archives a container upper directory into a compressed layer
+// and adds it to the image store (content store and snapshot).
+//
+// Returns the layer's digest, diffID and extracted size.
+func CreateLayer(upperDir string) (digest, diffID string, size int64, err error) {
+	if err := EnsureImageDirs(); err != nil {
+		return "", "", 0, fmt.Errorf("ensure image dirs: %w", err)
+	}
+
+	file, err := os.CreateTemp(DownloadDir, "commit-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("create temp layer: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, diffID, _, err = compressTar(file, func(w io.Writer) error {
+		return writeContainerLayer(w, upperDir)
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	digest, diffID, size, err = ExtractLayer(file.Name(), MediaTypeDockerLayerGzip, diffID)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("store layer: %w", err)
+	}
+	return digest, diffID, size, nil
+}
+
+// sameLayers reports whether the layer diffIDs resolve to the given layer directories.
+func sameLayers(diffIDs, layerDirs []string) bool {
+	if len(diffIDs) != len(layerDirs) {
+		return false
+	}
+	for i, diffID := range diffIDs {
+		if resolveLayerDir(layerDirs[i]) != SnapshotDir(diffID) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveLayerDir returns the snapshot directory a recorded layer directory
+// refers to. Containers created before the layer store migration recorded
+// old layer paths, which are now symlinks to their snapshots.
+func resolveLayerDir(dir string) string {
+	dir = filepath.Clean(dir)
+	if filepath.Dir(dir) != LayerBaseDir {
+		return dir
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return dir
+}
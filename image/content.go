@@ -0,0 +1,255 @@
+package image
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultStorageConfigPath is where image storage settings are read from.
+// Override with the MINICONTAINER_STORAGE_CONFIG environment variable.
+const DefaultStorageConfigPath = "/etc/minicontainer/storage.json"
+
+// Policies for keeping compressed layer blobs once they are unpacked.
+const (
+	KeepBlobsAlways = "always" // Keep them: push and save reuse the original blobs
+	KeepBlobsNever  = "never"  // Delete them: push and save re-archive the snapshots
+)
+
+// StorageConfig holds settings for the local image store.
+// Example /etc/minicontainer/storage.json:
+//
+//	{
+//	  "keep_blobs": "never"
+//	}
+type StorageConfig struct {
+	// KeepBlobs decides whether compressed layer blobs stay in the content
+	// store after they are unpacked into snapshots: KeepBlobsAlways (default)
+	// or KeepBlobsNever, which roughly halves the disk space an image takes.
+	// Manifests and configs are always kept.
+	KeepBlobs string `json:"keep_blobs"`
+}
+
+// LoadStorageConfig reads the storage config. A missing file yields defaults.
+func LoadStorageConfig() (*StorageConfig, error) {
+	path := os.Getenv("MINICONTAINER_STORAGE_CONFIG")
+	if path == "" {
+		path = DefaultStorageConfigPath
+	}
+
+	cfg := &StorageConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read storage config: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse storage config %s: %w", path, err)
+		}
+	}
+
+	switch cfg.KeepBlobs {
+	case "":
+		cfg.KeepBlobs = KeepBlobsAlways
+	case KeepBlobsAlways, KeepBlobsNever:
+	default:
+		return nil, fmt.Errorf("storage config %s: keep_blobs must be %q or %q, not %q",
+			path, KeepBlobsAlways, KeepBlobsNever, cfg.KeepBlobs)
+	}
+	return cfg, nil
+}
+
+// keepLayerBlobs reports whether layer blobs are kept after unpacking.
+func keepLayerBlobs() (bool, error) {
+	cfg, err := LoadStorageConfig()
+	if err != nil {
+		return false, err
+	}
+	return cfg.KeepBlobs == KeepBlobsAlways, nil
+}
+
+// HasContent reports whether the content store holds the blob with digest.
+func HasContent(digest string) bool {
+	info, err := os.Stat(ContentPath(digest))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// touchContent refreshes the mtime of a stored blob that is about to be
+// reused, so `image prune` counts it as new until the image using it is saved.
+func touchContent(digest string) error {
+	now := time.Now()
+	if err := os.Chtimes(ContentPath(digest), now, now); err != nil {
+		return fmt.Errorf("refresh blob %s: %w", shortDigest(digest), err)
+	}
+	return nil
+}
+
+// contentModTime returns when a blob was stored or last reused.
+func contentModTime(digest string) time.Time {
+	info, err := os.Stat(ContentPath(digest))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// writeContent stores an in-memory blob in the content store.
+// Returns the blob's digest.
+func writeContent(data []byte) (string, error) {
+	digest := digestBytes(data)
+	if HasContent(digest) && touchContent(digest) == nil {
+		return digest, nil
+	}
+
+	file, err := os.CreateTemp(DownloadDir, "content-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp blob: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), ContentPath(digest))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("store blob %s: %w", shortDigest(digest), err)
+	}
+	return digest, nil
+}
+
+// ingestContent adds the file at path to the content store as the blob with
+// digest. The content is verified while it is copied; a file that is already
+// verified and may be consumed (a finished download) is renamed instead.
+func ingestContent(path, digest string, move bool) error {
+	if HasContent(digest) && touchContent(digest) == nil {
+		if move {
+			os.Remove(path)
+		}
+		return nil
+	}
+	if move {
+		if err := os.Rename(path, ContentPath(digest)); err != nil {
+			return fmt.Errorf("store blob %s: %w", shortDigest(digest), err)
+		}
+		return nil
+	}
+
+	verifier, err := newDigestVerifier(digest)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(DownloadDir, "content-*")
+	if err != nil {
+		return fmt.Errorf("create temp blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(io.MultiWriter(tmp, verifier), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifier.Verify()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), ContentPath(digest))
+	}
+	if err != nil {
+		return fmt.Errorf("store blob %s: %w", shortDigest(digest), err)
+	}
+	return nil
+}
+
+// readContent reads a blob from the content store and verifies its digest.
+func readContent(digest string) ([]byte, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(ContentPath(digest))
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s: %w", shortDigest(digest), err)
+	}
+	if got := digestBytes(data); got != digest {
+		return nil, fmt.Errorf("blob %s is corrupt: digest is %s", shortDigest(digest), got)
+	}
+	return data, nil
+}
+
+// contentSize returns the size of a stored blob.
+func contentSize(digest string) (int64, error) {
+	info, err := os.Stat(ContentPath(digest))
+	if err != nil {
+		return 0, fmt.Errorf("stat blob %s: %w", shortDigest(digest), err)
+	}
+	return info.Size(), nil
+}
+
+// contentCompression detects the compression of a stored layer blob from its
+// magic bytes: compressionGzip, compressionZstd or compressionNone.
+func contentCompression(digest string) (string, error) {
+	file, err := os.Open(ContentPath(digest))
+	if err != nil {
+		return "", fmt.Errorf("open blob %s: %w", shortDigest(digest), err)
+	}
+	defer file.Close()
+	return detectCompression(bufio.NewReader(file))
+}
+
+// removeContent deletes a blob from the content store (nil if it is absent).
+func removeContent(digest string) error {
+	if err := os.Remove(ContentPath(digest)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove blob %s: %w", shortDigest(digest), err)
+	}
+	return nil
+}
+
+// lockContent takes an exclusive lock on a blob digest, waiting for other
+// processes (or goroutines) downloading or unpacking the same blob.
+// Returns the function releasing the lock.
+func lockContent(digest string) (func(), error) {
+	unlock, _, err := flockFile(lockPath(contentLockDir, digest), unix.LOCK_EX)
+	return unlock, err
+}
+
+// tryLockContent takes the lock on a blob digest if it is free.
+// Returns the function releasing the lock, or false if the blob is busy.
+func tryLockContent(digest string) (func(), bool, error) {
+	return flockFile(lockPath(contentLockDir, digest), unix.LOCK_EX|unix.LOCK_NB)
+}
+
+// flockFile locks the lock file at path with flock(2) using how.
+// Locks are per open file, so goroutines of one process exclude each other too.
+func flockFile(path string, how int) (func(), bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, false, fmt.Errorf("create lock dir: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, false, fmt.Errorf("open lock: %w", err)
+	}
+	if err := unix.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("lock %s: %w", shortDigest(filepath.Base(path)), err)
+	}
+	return func() {
+		unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		file.Close()
+	}, true, nil
+}
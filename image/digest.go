@@ -0,0 +1,47 @@
+package image
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+)
+
+// digestVerifier hashes content as it is streamed and checks it against
+// an expected OCI digest once the stream is complete.
+// Use it as the writer side of an io.TeeReader or io.MultiWriter.
+type digestVerifier struct {
+	expected string    // Expected digest in "sha256:<hex>" format
+	hasher   hash.Hash // Running SHA256 of everything written so far
+}
+
+// newDigestVerifier creates a verifier for the given "sha256:<hex>" digest.
+// Returns an error for unsupported algorithms or malformed digests.
+func newDigestVerifier(expected string) (*digestVerifier, error) {
+	if err := ValidateDigest(expected); err != nil {
+		return nil, err
+	}
+	return &digestVerifier{expected: expected, hasher: sha256.New()}, nil
+}
+
+// Write implements io.Writer by feeding p into the hash.
+func (v *digestVerifier) Write(p []byte) (int, error) {
+	return v.hasher.Write(p)
+}
+
+// Digest returns the digest of the content written so far.
+func (v *digestVerifier) Digest() string {
+	return fmt.Sprintf("sha256:%x", v.hasher.Sum(nil))
+}
+
+// Verify returns an error if the streamed content does not match the expected digest.
+func (v *digestVerifier) Verify() error {
+	if actual := v.Digest(); actual != v.expected {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", v.expected, actual)
+	}
+	return nil
+}
+
+// digestBytes returns the "sha256:<hex>" digest of data.
+func digestBytes(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
@@ -0,0 +1,424 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
+)
+
+// OCI whiteout markers used by image layers to record deletions.
+// See: https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	whiteoutPrefix = ".wh."         // ".wh.<name>" deletes <name> from lower layers
+	whiteoutMeta   = ".wh..wh."     // Reserved prefix for whiteout metadata entries
+	whiteoutOpaque = ".wh..wh..opq" // Hides all lower-layer contents of its directory
+	overlayOpaque  = "trusted.overlay.opaque"
+	paxXattrPrefix = "SCHILY.xattr." // PAX record prefix for extended attributes
+	maxSymlinkHops = 255             // Guard against symlink loops when resolving paths
+)
+
+// deferredDir records directory metadata applied after all entries are extracted.
+// Directories are finalized last so restrictive modes don't block writing their
+// children, and so creating children doesn't bump their mtimes.
+type deferredDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// extractLayerTar extracts an image layer tar stream into destDir.
+// Converts OCI whiteouts into their overlayfs representation:
+//   - ".wh.<name>"    -> character device 0/0 named <name>
+//   - ".wh..wh..opq"  -> "trusted.overlay.opaque=y" xattr on the parent directory
+//
+// Preserves ownership, permissions, modification times, hardlinks, symlinks,
+// device nodes, FIFOs and extended attributes. Entries that would escape
+// destDir (via ".." or by writing through a symlink) are refused.
+//
+// Parameters:
+//   - r: uncompressed tar stream
+//   - destDir: directory to extract contents into (must exist)
+//
+// Returns:
+//   - error: any error during extraction
+func extractLayerTar(r io.Reader, destDir string) error {
+	tr := tar.NewReader(r)
+	var dirs []deferredDir
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar entry: %w", err)
+		}
+		if !extractableType(hdr.Typeflag) {
+			// PAX global headers (e.g. from git archive) and vendor-specific
+			// entry types describe no file; skip them like Docker does
+			continue
+		}
+
+		// Step 1: Normalize the entry name and reject path traversal
+		name, err := cleanEntryName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			// Root directory entry: apply its metadata to destDir itself
+			dirs = append(dirs, deferredDir{destDir, hdr.FileInfo().Mode(), hdr.ModTime})
+			continue
+		}
+
+		// Step 2: Resolve the parent directory inside destDir without following
+		// symlinks out of it, creating missing parents along the way
+		parent, err := resolveInRoot(destDir, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return fmt.Errorf("create parent of %s: %w", name, err)
+		}
+		base := filepath.Base(name)
+		path := filepath.Join(parent, base)
+
+		// Step 3: Translate whiteouts into overlayfs whiteouts
+		if base == whiteoutOpaque {
+			if err := unix.Lsetxattr(parent, overlayOpaque, []byte("y"), 0); err != nil {
+				return fmt.Errorf("mark %s opaque: %w", filepath.Dir(name), err)
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutMeta) {
+			continue // Other whiteout metadata (e.g., hardlink dirs) has no overlay meaning
+		}
+		if hidden, ok := strings.CutPrefix(base, whiteoutPrefix); ok {
+			// ".wh.", ".wh.." and ".wh..." would whiteout the parent or its parent
+			if hidden == "" || hidden == "." || hidden == ".." || strings.Contains(hidden, "/") {
+				return fmt.Errorf("refusing tar entry %q: invalid whiteout name", hdr.Name)
+			}
+			target := filepath.Join(parent, hidden)
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("remove whited-out %s: %w", hidden, err)
+			}
+			if err := unix.Mknod(target, unix.S_IFCHR, 0); err != nil {
+				return fmt.Errorf("create whiteout for %s: %w", hidden, err)
+			}
+			continue
+		}
+
+		// Step 4: Replace whatever exists at path, unless both are directories
+		if info, err := os.Lstat(path); err == nil {
+			if !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+				if err := os.RemoveAll(path); err != nil {
+					return fmt.Errorf("replace %s: %w", name, err)
+				}
+			}
+		}
+
+		// Step 5: Create the entry
+		if err := createEntry(tr, hdr, destDir, path); err != nil {
+			return fmt.Errorf("extract %s: %w", name, err)
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			continue // Hardlinks share metadata with their target
+		}
+
+		// Step 6: Apply ownership, permissions, xattrs and times
+		if err := applyMetadata(hdr, path); err != nil {
+			return fmt.Errorf("set metadata on %s: %w", name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, deferredDir{path, hdr.FileInfo().Mode(), hdr.ModTime})
+		}
+	}
+
+	// Finalize directories deepest-first so parent mtimes are not disturbed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.mode); err != nil {
+			return fmt.Errorf("chmod %s: %w", d.path, err)
+		}
+		ts := []unix.Timespec{unix.NsecToTimespec(d.modTime.UnixNano()), unix.NsecToTimespec(d.modTime.UnixNano())}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, d.path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return fmt.Errorf("set times on %s: %w", d.path, err)
+		}
+	}
+
+	return nil
+}
+
+// createEntry creates a single filesystem object for a tar header.
+func createEntry(tr *tar.Reader, hdr *tar.Header, destDir, path string) error {
+	mode := uint32(hdr.FileInfo().Mode().Perm())
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0o755); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+	case tar.TypeReg, tar.TypeCont, tar.TypeGNUSparse:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+
+	case tar.TypeSymlink:
+		// The link target is stored verbatim; it is only dangerous if later
+		// entries are written through it, which resolveInRoot refuses
+		return os.Symlink(hdr.Linkname, path)
+
+	case tar.TypeLink:
+		linkName, err := cleanEntryName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		// Resolve only the parent: a hardlink to a symlink links the symlink itself
+		targetDir, err := resolveInRoot(destDir, filepath.Dir(linkName))
+		if err != nil {
+			return err
+		}
+		return os.Link(filepath.Join(targetDir, filepath.Base(linkName)), path)
+
+	case tar.TypeChar:
+		return unix.Mknod(path, unix.S_IFCHR|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+
+	case tar.TypeBlock:
+		return unix.Mknod(path, unix.S_IFBLK|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+
+	case tar.TypeFifo:
+		return unix.Mkfifo(path, mode)
+
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+
+	return nil
+}
+
+// extractableType reports whether a tar entry type describes a filesystem
+// object that extractLayerTar can create.
+func extractableType(typeflag byte) bool {
+	switch typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeCont, tar.TypeGNUSparse, tar.TypeSymlink,
+		tar.TypeLink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+// allowedXattr reports whether a layer may set the extended attribute attr.
+// trusted.* and security.* attributes are interpreted by the kernel: a layer
+// carrying trusted.overlay.* could redirect or hide lower-layer files once
+// mounted, so only file capabilities (security.capability) are kept.
+// user.* and system.* (POSIX ACLs) are applied as-is.
+//
+// Examples:
+//   - "user.comment"           -> true
+//   - "security.capability"    -> true
+//   - "security.selinux"       -> false
+//   - "trusted.overlay.opaque" -> false
+func allowedXattr(attr string) bool {
+	if attr == "security.capability" {
+		return true
+	}
+	return !strings.HasPrefix(attr, "trusted.") && !strings.HasPrefix(attr, "security.")
+}
+
+// applyMetadata sets ownership, permissions, xattrs and modification time.
+// Ownership is applied before the mode, since chown clears setuid/setgid bits.
+func applyMetadata(hdr *tar.Header, path string) error {
+	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+		// Unprivileged extraction cannot preserve ownership; keep going
+		if os.Geteuid() == 0 {
+			return err
+		}
+	}
+
+	// Directory modes are applied last by extractLayerTar
+	if hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeDir {
+		if err := os.Chmod(path, hdr.FileInfo().Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range hdr.PAXRecords {
+		attr, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok || !allowedXattr(attr) {
+			continue
+		}
+		if err := unix.Lsetxattr(path, attr, []byte(value), 0); err != nil {
+			// Filesystems without xattr support (or user.* restrictions) are not fatal
+			if !errors.Is(err, unix.ENOTSUP) && !errors.Is(err, unix.EPERM) {
+				return fmt.Errorf("set xattr %s: %w", attr, err)
+			}
+		}
+	}
+
+	if hdr.Typeflag != tar.TypeDir {
+		ts := []unix.Timespec{unix.NsecToTimespec(hdr.AccessTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+		if hdr.AccessTime.IsZero() {
+			ts[0] = ts[1]
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cleanEntryName normalizes a tar entry name to a relative, clean path.
+// Leading "/" is stripped (absolute entries are relative to the layer root),
+// and names that climb above the root via ".." are rejected.
+//
+// Examples:
+//   - "./etc/passwd"   -> "etc/passwd"
+//   - "/usr/bin/env"   -> "usr/bin/env"
+//   - "../etc/shadow"  -> error
+func cleanEntryName(name string) (string, error) {
+	cleaned := filepath.Clean(strings.TrimLeft(name, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("refusing tar entry %q: escapes destination", name)
+	}
+	return cleaned, nil
+}
+
+// resolveInRoot resolves a relative path inside root, following symlinks in
+// its components only while they stay inside root.
+// A component that is an absolute symlink, or a relative symlink climbing
+// above root, is refused so that no entry is ever written outside root.
+//
+// Parameters:
+//   - root: extraction root directory
+//   - rel: clean relative path (from cleanEntryName)
+//
+// Returns:
+//   - absolute host path under root
+//   - error if resolution would leave root
+func resolveInRoot(root, rel string) (string, error) {
+	resolved := "" // Path resolved so far, relative to root
+	remaining := strings.Split(rel, "/")
+	hops := 0
+
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			if resolved == "" {
+				return "", fmt.Errorf("refusing path %q: escapes destination", rel)
+			}
+			resolved = filepath.Dir(resolved)
+			if resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// Missing components will be created; regular ones are fine
+			resolved = next
+			continue
+		}
+
+		// Component is a symlink: only follow it if it stays inside root
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("refusing path %q: too many levels of symbolic links", rel)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", fmt.Errorf("read symlink %s: %w", next, err)
+		}
+		if filepath.IsAbs(target) {
+			return "", fmt.Errorf("refusing path %q: component %s is an absolute symlink", rel, next)
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+
+	return filepath.Join(root, resolved), nil
+}
+
+// decompressStream wraps r with the decompressor for the given compression
+// (compressionNone, compressionGzip or compressionZstd). An empty compression
+// is detected from the stream's magic bytes, for tarballs that come without
+// a media type such as `import` input; unrecognized streams are read as plain tar.
+// The caller must close the returned reader.
+func decompressStream(r io.Reader, compression string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if compression == "" {
+		var err error
+		if compression, err = detectCompression(br); err != nil {
+			return nil, err
+		}
+	}
+
+	switch compression {
+	case compressionGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream: %w", err)
+		}
+		return gz, nil
+
+	case compressionZstd:
+		// A single-goroutine decoder decodes synchronously as it is read
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("open zstd stream: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+
+	case compressionNone:
+		return io.NopCloser(br), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// Magic bytes at the start of compressed streams.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression peeks at the start of br and returns the compression
+// its magic bytes indicate, or compressionNone.
+func detectCompression(br *bufio.Reader) (string, error) {
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("read stream header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return compressionGzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return compressionZstd, nil
+	default:
+		return compressionNone, nil
+	}
+}
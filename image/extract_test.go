@@ -0,0 +1,297 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// tarEntry describes one entry of a test layer: a directory if name ends
+// in "/", a regular file with content otherwise.
+type tarEntry struct {
+	name    string
+	content string
+}
+
+// buildLayer returns an uncompressed layer tar holding entries, in order.
+func buildLayer(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		if e.name[len(e.name)-1] == '/' {
+			hdr.Mode, hdr.Typeflag, hdr.Size = 0o755, tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// requireRoot skips tests that create device nodes and trusted xattrs.
+func requireRoot(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("whiteouts need root (mknod, trusted.* xattrs)")
+	}
+}
+
+// isOverlayWhiteout reports whether path is a 0/0 character device.
+func isOverlayWhiteout(t *testing.T, path string) bool {
+	t.Helper()
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return false
+	}
+	return st.Mode&unix.S_IFMT == unix.S_IFCHR && st.Rdev == 0
+}
+
+func TestExtractLayerTarWhiteout(t *testing.T) {
+	requireRoot(t)
+	dest := t.TempDir()
+
+	// A lower layer left etc/keep and etc/gone behind; the whiteout replaces gone
+	if err := os.MkdirAll(filepath.Join(dest, "etc", "gone"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "etc", "gone", "file"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	layer := buildLayer(t,
+		tarEntry{name: "etc/"},
+		tarEntry{name: "etc/keep", content: "kept"},
+		tarEntry{name: "etc/.wh.gone"},
+		tarEntry{name: "etc/.wh..wh.plnk"},
+	)
+	if err := extractLayerTar(layer, dest); err != nil {
+		t.Fatalf("extractLayerTar: %v", err)
+	}
+
+	if !isOverlayWhiteout(t, filepath.Join(dest, "etc", "gone")) {
+		t.Error("etc/gone is not an overlay whiteout")
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "etc", "keep")); err != nil || string(data) != "kept" {
+		t.Errorf("etc/keep = %q, %v; want \"kept\"", data, err)
+	}
+	for _, name := range []string{".wh.gone", ".wh..wh.plnk", "..wh.plnk"} {
+		if _, err := os.Lstat(filepath.Join(dest, "etc", name)); !os.IsNotExist(err) {
+			t.Errorf("whiteout marker etc/%s was extracted as a file", name)
+		}
+	}
+}
+
+func TestExtractLayerTarOpaque(t *testing.T) {
+	requireRoot(t)
+	dest := t.TempDir()
+
+	layer := buildLayer(t,
+		tarEntry{name: "app/"},
+		tarEntry{name: "app/.wh..wh..opq"},
+		tarEntry{name: "app/new", content: "new"},
+		tarEntry{name: "other/"},
+	)
+	if err := extractLayerTar(layer, dest); err != nil {
+		t.Fatalf("extractLayerTar: %v", err)
+	}
+
+	buf := make([]byte, 8)
+	n, err := unix.Lgetxattr(filepath.Join(dest, "app"), overlayOpaque, buf)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			t.Skip("temp dir does not support trusted.* xattrs")
+		}
+		t.Fatalf("app is not opaque: %v", err)
+	}
+	if string(buf[:n]) != "y" {
+		t.Errorf("app opaque xattr = %q, want \"y\"", buf[:n])
+	}
+	if _, err := unix.Lgetxattr(filepath.Join(dest, "other"), overlayOpaque, buf); err == nil {
+		t.Error("other is opaque but has no opaque marker")
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "app", whiteoutOpaque)); !os.IsNotExist(err) {
+		t.Error("opaque marker was extracted as a file")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "app", "new")); err != nil {
+		t.Errorf("app/new missing: %v", err)
+	}
+}
+
+// checkRefused extracts a layer holding only entry into a child of a scratch
+// dir, so climbing out is visible, and checks it was refused without
+// touching anything above or at the entry's parent.
+func checkRefused(t *testing.T, entry string) {
+	t.Helper()
+	scratch := t.TempDir()
+	dest := filepath.Join(scratch, "layer")
+	if err := os.MkdirAll(filepath.Join(dest, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "sub", "file"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	layer := buildLayer(t, tarEntry{name: entry})
+	if err := extractLayerTar(layer, dest); err == nil {
+		t.Fatalf("extractLayerTar accepted %q", entry)
+	}
+
+	for _, dir := range []string{scratch, dest, filepath.Join(dest, "sub")} {
+		info, err := os.Lstat(dir)
+		if err != nil || !info.IsDir() {
+			t.Errorf("%s was removed or replaced", dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "sub", "file")); err != nil {
+		t.Errorf("sub/file was removed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(scratch, "escape")); !os.IsNotExist(err) {
+		t.Error("entry was written outside the destination")
+	}
+}
+
+func TestExtractLayerTarRefusesBadWhiteouts(t *testing.T) {
+	requireRoot(t)
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"empty whiteout", ".wh."},
+		{"whiteout of dot", ".wh.."},
+		{"whiteout of dot dot", ".wh..."},
+		{"nested empty whiteout", "sub/.wh."},
+		{"nested whiteout of dot", "sub/.wh.."},
+		{"nested whiteout of dot dot", "sub/.wh..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRefused(t, tt.entry)
+		})
+	}
+}
+
+func TestExtractLayerTarRefusesTraversal(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"parent traversal", "../escape"},
+		{"nested traversal", "sub/../../escape"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRefused(t, tt.entry)
+		})
+	}
+}
+
+func TestExtractLayerTarSkipsGlobalHeader(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "0123abcd"}},
+		{Name: "file", Mode: 0o644, Typeflag: tar.TypeReg, Size: 4},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tw.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	if err := extractLayerTar(&buf, dest); err != nil {
+		t.Fatalf("extractLayerTar: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "pax_global_header")); !os.IsNotExist(err) {
+		t.Error("global header was extracted as a file")
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "file")); err != nil || string(data) != "data" {
+		t.Errorf("file = %q, %v; want \"data\"", data, err)
+	}
+}
+
+func TestAllowedXattr(t *testing.T) {
+	tests := []struct {
+		attr    string
+		allowed bool
+	}{
+		{"user.comment", true},
+		{"system.posix_acl_access", true},
+		{"security.capability", true},
+		{"security.selinux", false},
+		{"security.ima", false},
+		{"trusted.overlay.opaque", false},
+		{"trusted.overlay.redirect", false},
+		{"trusted.overlay.metacopy", false},
+		{"trusted.other", false},
+	}
+
+	for _, tt := range tests {
+		if got := allowedXattr(tt.attr); got != tt.allowed {
+			t.Errorf("allowedXattr(%q) = %v, want %v", tt.attr, got, tt.allowed)
+		}
+	}
+}
+
+func TestExtractLayerTarDropsOverlayXattrs(t *testing.T) {
+	requireRoot(t)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Name: "dir/", Mode: 0o755, Typeflag: tar.TypeDir, PAXRecords: map[string]string{
+			paxXattrPrefix + overlayOpaque: "y",
+		}},
+		{Name: "dir/file", Mode: 0o644, Typeflag: tar.TypeReg, PAXRecords: map[string]string{
+			paxXattrPrefix + "trusted.overlay.redirect": "/etc",
+			paxXattrPrefix + "user.comment":             "kept",
+		}},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	if err := extractLayerTar(&buf, dest); err != nil {
+		t.Fatalf("extractLayerTar: %v", err)
+	}
+
+	attr := make([]byte, 16)
+	if _, err := unix.Lgetxattr(filepath.Join(dest, "dir"), overlayOpaque, attr); err == nil {
+		t.Error("dir was made opaque by a PAX xattr")
+	}
+	if _, err := unix.Lgetxattr(filepath.Join(dest, "dir", "file"), "trusted.overlay.redirect", attr); err == nil {
+		t.Error("file got a trusted.overlay.redirect xattr")
+	}
+	n, err := unix.Lgetxattr(filepath.Join(dest, "dir", "file"), "user.comment", attr)
+	if err == unix.ENOTSUP {
+		t.Skip("temp dir does not support user.* xattrs")
+	}
+	if err != nil || string(attr[:n]) != "kept" {
+		t.Errorf("user.comment = %q, %v; want \"kept\"", attr[:n], err)
+	}
+}
@@ -0,0 +1,79 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// HistoryItem is one step of an image's history as shown by `image history`.
+type HistoryItem struct {
+	Layer     string     // Digest of the layer the step added ("" for config-only steps)
+	Created   *time.Time // When the step ran (nil if unknown)
+	CreatedBy string     // Command that created the step
+	Comment   string     // Commit message or other comment
+	Size      int64      // Size of the layer's files in bytes (0 for config-only steps)
+}
+
+// ImageHistory returns the steps that built an image, newest first.
+// Config history entries are matched to layers in order, skipping entries
+// marked empty_layer. If there are fewer entries than layers, the entries
+// belong to the top layers: images committed or built on an import only
+// have history for their own layers. Layers without an entry are listed
+// with an empty CreatedBy.
+//
+// Parameters:
+//   - ref: image reference ("name:tag" or "name@sha256:...") or image ID
+//
+// Returns:
+//   - []HistoryItem: the image history, top layer first
+//   - error: if the image is not found or its config or layers cannot be read
+func ImageHistory(ref string) ([]HistoryItem, error) {
+	// Step 1: Load the image, its config history and layer sizes
+	meta, err := ResolveImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	var history []HistoryEntry
+	config, err := LoadConfig(meta.ID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("load image config: %w", err)
+		}
+	} else {
+		history = config.History
+	}
+	layers, err := layerInfos(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 2: Bottom layers without history come first
+	withLayer := 0
+	for _, entry := range history {
+		if !entry.EmptyLayer {
+			withLayer++
+		}
+	}
+	next := max(len(layers)-withLayer, 0)
+
+	var items []HistoryItem
+	for _, layer := range layers[:next] {
+		items = append(items, HistoryItem{Layer: layer.Digest, Size: layer.Size})
+	}
+
+	// Step 3: Pair each non-empty history entry with the next layer
+	for _, entry := range history {
+		item := HistoryItem{Created: entry.Created, CreatedBy: entry.CreatedBy, Comment: entry.Comment}
+		if !entry.EmptyLayer && next < len(layers) {
+			item.Layer = layers[next].Digest
+			item.Size = layers[next].Size
+			next++
+		}
+		items = append(items, item)
+	}
+
+	slices.Reverse(items)
+	return items, nil
+}
@@ -6,46 +6,17 @@ import (
 	"time"
 )
 
-// ParseImageRef splits an image reference into name and tag.
-// If no tag is provided, defaults to "latest".
-//
-// Examples:
-//   - "alpine" -> ("alpine", "latest")
-//   - "alpine:3.19" -> ("alpine", "3.19")
-//   - "myapp:v1.0" -> ("myapp", "v1.0")
-//
-// Parameters:
-//   - ref: image reference string in "name" or "name:tag" format
-//
-// Returns:
-//   - name: the image name
-//   - tag: the image tag (defaults to "latest" if not specified)
-func ParseImageRef(ref string) (name, tag string) {
-	// Split on ":" - if present, we have name:tag
-	// If not, name only with default tag "latest"
-	parts := strings.SplitN(ref, ":", 2)
-	name = parts[0]
-
-	if len(parts) == 2 {
-		tag = parts[1]
-	} else {
-		tag = "latest"
-	}
-
-	return name, tag
-}
-
 // ImportTarball imports a rootfs tarball as a single-layer image.
 // This creates an image that can be used with `minicontainer run <name:tag>`.
 //
 // The process:
 //  1. Ensure base directories exist
-//  2. Extract the tarball to a layer directory (content-addressable)
+//  2. Store the tarball as a blob and extract it to a snapshot (content-addressable)
 //  3. Create image metadata with the layer digest
 //  4. Save metadata to the image directory
 //
 // Parameters:
-//   - tarballPath: path to the .tar or .tar.gz rootfs archive
+//   - tarballPath: path to the .tar, .tar.gz or .tar.zst rootfs archive
 //   - ref: image reference in "name" or "name:tag" format
 //
 // Returns:
@@ -58,11 +29,15 @@ func ImportTarball(tarballPath, ref string) (*ImageMetadata, error) {
 	}
 
 	// Step 2: Parse the image reference into name and tag
-	name, tag := ParseImageRef(ref)
+	parsed, err := ParseTagReference(ref)
+	if err != nil {
+		return nil, err
+	}
 
-	// Step 3: Extract the tarball to a content-addressable layer directory
-	// ExtractLayer returns the digest (used as layer ID) and size
-	digest, size, err := ExtractLayer(tarballPath)
+	// Step 3: Extract the tarball to a content-addressable snapshot
+	// ExtractLayer returns the digest (blob ID), diffID (snapshot ID) and size;
+	// the compression (none, gzip or zstd) is detected from the content
+	digest, diffID, size, err := ExtractLayer(tarballPath, "", "")
 	if err != nil {
 		return nil, fmt.Errorf("extract layer: %w", err)
 	}
@@ -72,9 +47,10 @@ func ImportTarball(tarballPath, ref string) (*ImageMetadata, error) {
 	// (since there's only one layer, its digest uniquely identifies the image)
 	meta := &ImageMetadata{
 		ID:        strings.TrimPrefix(digest, "sha256:"), // Store just the hex part
-		Name:      name,
-		Tag:       tag,
+		Name:      parsed.Name(),
+		Tag:       parsed.Tag,
 		Layers:    []string{digest}, // Single layer for imported tarball
+		DiffIDs:   []string{diffID},
 		CreatedAt: time.Now(),
 		Size:      size,
 		// ConfigDigest is empty for imported images (no OCI config)
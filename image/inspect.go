@@ -0,0 +1,94 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ImageInspection is the detailed view of a local image printed by `image inspect`.
+// It combines the stored metadata, all tags, the image config and the layers.
+type ImageInspection struct {
+	ID           string       `json:"id"`            // Image ID (64 hex chars)
+	RepoTags     []string     `json:"repo_tags"`     // All "name:tag" references to the image
+	RepoDigests  []string     `json:"repo_digests"`  // "name@sha256:..." manifest digests
+	ConfigDigest string       `json:"config_digest"` // Digest of the config blob
+	Platform     string       `json:"platform"`      // "os/arch[/variant]" (empty for imports)
+	CreatedAt    time.Time    `json:"created_at"`    // When the image was stored locally
+	Size         int64        `json:"size"`          // Total size in bytes
+	Layers       []LayerInfo  `json:"layers"`        // Layers, bottom to top
+	Config       *ImageConfig `json:"config"`        // Stored image config (nil for imports)
+}
+
+// LayerInfo describes one layer of an image.
+type LayerInfo struct {
+	Digest     string `json:"digest"`      // Compressed layer digest, the blob's key in the content store
+	DiffID     string `json:"diff_id"`     // Uncompressed layer digest, the snapshot key
+	Size       int64  `json:"size"`        // Size of the extracted layer's files in bytes
+	BlobStored bool   `json:"blob_stored"` // Whether the compressed blob is kept in the content store
+}
+
+// InspectImage collects everything known about a local image.
+//
+// Parameters:
+//   - ref: image reference ("name:tag" or "name@sha256:...") or image ID
+//
+// Returns:
+//   - *ImageInspection: metadata, tags, config and per-layer details
+//   - error: if the image is not found or its config or layers cannot be read
+func InspectImage(ref string) (*ImageInspection, error) {
+	// Step 1: Load the metadata, tags and config
+	meta, err := ResolveImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := ImageTags(meta.ID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadConfig(meta.ID)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load image config: %w", err)
+	}
+
+	inspection := &ImageInspection{
+		ID:           meta.ID,
+		RepoTags:     tags,
+		RepoDigests:  meta.RepoDigests,
+		ConfigDigest: meta.ConfigDigest,
+		Platform:     meta.Platform,
+		CreatedAt:    meta.CreatedAt,
+		Size:         meta.Size,
+		Config:       config,
+	}
+	if inspection.RepoTags == nil {
+		inspection.RepoTags = []string{}
+	}
+	if inspection.RepoDigests == nil {
+		inspection.RepoDigests = []string{}
+	}
+
+	// Step 2: Measure each layer
+	inspection.Layers, err = layerInfos(meta)
+	if err != nil {
+		return nil, err
+	}
+	return inspection, nil
+}
+
+// layerInfos returns the digest, diffID, extracted size and blob state of
+// each layer of meta.
+func layerInfos(meta *ImageMetadata) ([]LayerInfo, error) {
+	if len(meta.DiffIDs) != len(meta.Layers) {
+		return nil, fmt.Errorf("image %s has no layer diff_ids, pull or import it again", shortDigest(meta.ID))
+	}
+	layers := make([]LayerInfo, len(meta.Layers))
+	for i, digest := range meta.Layers {
+		size, err := dirSize(SnapshotDir(meta.DiffIDs[i]))
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", shortDigest(digest), err)
+		}
+		layers[i] = LayerInfo{Digest: digest, DiffID: meta.DiffIDs[i], Size: size, BlobStored: HasContent(digest)}
+	}
+	return layers, nil
+}
@@ -1,114 +1,411 @@
 package image
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
-// ExtractLayer extracts a tarball to the layer directory and returns its digest.
-// The digest is computed from the tarball content (SHA256) and used as the
-// directory name for content-addressable storage.
+// ExtractLayer adds a layer tarball to the image store and returns its digests.
+// The digest is computed from the tarball content (SHA256) and identifies the
+// blob in the content store. The diffID is the SHA256 of the uncompressed tar
+// stream, matching the image config's rootfs.diff_ids, and keys the snapshot
+// the tarball is extracted into. The tarball is decompressed according to its
+// layer media type; without one (imported tarballs, docker archives) the
+// compression is detected from its magic bytes.
+//
+// The tarball is copied into the content store unless the storage config
+// says not to keep layer blobs. Extraction is crash-safe and holds the blob's
+// lock, so concurrent callers extract it once (see unpackLayerLocked).
 //
 // Parameters:
-//   - tarballPath: path to the .tar or .tar.gz file to extract
+//   - tarballPath: path to the .tar, .tar.gz or .tar.zst file to extract
+//   - mediaType: layer media type from the manifest ("" to detect the compression)
+//   - diffID: expected diffID from the image config ("" if unknown); a
+//     tarball with different content is rejected before it is stored
 //
 // Returns:
-//   - digest: the "sha256:<hex>" content hash identifying this layer
+//   - digest: the "sha256:<hex>" hash of the (possibly compressed) tarball
+//   - diffID: the "sha256:<hex>" hash of the uncompressed tar stream
 //   - size: total bytes of the extracted layer
 //   - error: any error during extraction
 //
-// The layer is stored at: /var/lib/minicontainer/layers/sha256:<hash>/
-func ExtractLayer(tarballPath string) (digest string, size int64, err error) {
+// The snapshot is stored at: /var/lib/minicontainer/snapshots/<diffID hex>/
+func ExtractLayer(tarballPath, mediaType, diffID string) (string, string, int64, error) {
+	compression, err := layerCompression(mediaType)
+	if err != nil {
+		return "", "", 0, err
+	}
+	keep, err := keepLayerBlobs()
+	if err != nil {
+		return "", "", 0, err
+	}
+
 	// Step 1: Compute digest of the tarball file
-	// This gives us the content-addressable name for the layer
-	digest, err = computeDigest(tarballPath)
+	// This gives us the content-addressable name of the blob
+	digest, err := computeDigest(tarballPath)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("compute layer digest: %w", err)
+	}
+
+	// Step 2: Extract under the blob's lock
+	unlock, err := lockContent(digest)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer unlock()
+
+	diffID, size, err := unpackLayerLocked(tarballPath, digest, diffID, compression)
 	if err != nil {
-		return "", 0, fmt.Errorf("compute layer digest: %w", err)
+		return "", "", 0, err
+	}
+
+	// Step 3: Keep the blob, so push and save can reuse it
+	if keep {
+		if err := ingestContent(tarballPath, digest, false); err != nil {
+			return "", "", 0, err
+		}
+	}
+	return digest, diffID, size, nil
+}
+
+// unpackLayerLocked extracts the blob at blobPath into the snapshot of its
+// diffID unless that snapshot already exists. The caller must hold the
+// blob's content lock.
+//
+// The blob is extracted into a staging directory and checked against the
+// expected diffID; only then is it renamed into place under the snapshot's
+// lock, and the snapshot's completion marker is written last. A snapshot
+// directory without a marker was left by an interrupted extraction or
+// removal; it is discarded and extracted again.
+//
+// Parameters:
+//   - blobPath: the verified layer blob
+//   - digest: the blob's digest (names the staging directory)
+//   - diffID: expected diffID ("" to compute it from the blob)
+//   - compression: compression of the blob ("" to detect it)
+//
+// Returns the layer's diffID and extracted size.
+func unpackLayerLocked(blobPath, digest, diffID, compression string) (string, int64, error) {
+	// Step 1: Without an expected diffID (imports), hash the uncompressed
+	// stream first, so an existing snapshot is found without extracting
+	if diffID == "" {
+		var err error
+		if diffID, err = computeDiffID(blobPath, compression); err != nil {
+			return "", 0, fmt.Errorf("compute layer diffID: %w", err)
+		}
 	}
 
-	// Step 2: Check if this layer is already cached
-	// Content-addressable storage means identical content = identical digest
-	if LayerExists(digest) {
-		// Layer already extracted, get its size and return
-		size, err = dirSize(LayerDir(digest))
+	// Step 2: Check if this snapshot is already there
+	// Content-addressable storage means identical content = identical diffID
+	reused, err := touchSnapshot(diffID)
+	if err != nil {
+		return "", 0, err
+	}
+	if reused {
+		size, err := dirSize(SnapshotDir(diffID))
 		if err != nil {
 			return "", 0, fmt.Errorf("get cached layer size: %w", err)
 		}
-		return digest, size, nil
+		return diffID, size, nil
 	}
 
-	// Step 3: Create the layer directory
-	layerID := strings.TrimPrefix(digest, "sha256:")
-	layerPath := LayerDir(layerID)
-	if err = os.MkdirAll(layerPath, 0o755); err != nil {
-		return "", 0, fmt.Errorf("create layer dir: %w", err)
+	// Step 3: Extract tarball into a staging directory
+	// Native extraction decompresses in-process and converts OCI whiteouts
+	staging, err := os.MkdirTemp(SnapshotStagingDir, strings.TrimPrefix(digest, "sha256:")+"-")
+	if err != nil {
+		return "", 0, fmt.Errorf("create staging dir: %w", err)
 	}
-
-	// Step 4: Extract tarball to layer directory
-	// Use tar command for simplicity - handles .tar and .tar.gz automatically
-	size, err = extractTarball(tarballPath, layerPath)
+	defer os.RemoveAll(staging) // Gone after the rename unless something failed
+	if err := os.Chmod(staging, 0o755); err != nil {
+		return "", 0, fmt.Errorf("create staging dir: %w", err)
+	}
+	actual, size, err := extractTarball(blobPath, staging, compression)
 	if err != nil {
-		// Clean up partial extraction on failure
-		os.RemoveAll(layerPath)
 		return "", 0, fmt.Errorf("extract tarball: %w", err)
 	}
+	if actual != diffID {
+		return "", 0, fmt.Errorf("diffID mismatch: expected %s, got %s", diffID, actual)
+	}
+
+	// Step 4: Move the finished snapshot into place, then mark it complete
+	unlock, err := lockSnapshot(diffID)
+	if err != nil {
+		return "", 0, err
+	}
+	defer unlock()
+	if SnapshotExists(diffID) {
+		return diffID, size, nil // Extracted meanwhile from a blob compressed differently
+	}
+	if err := os.RemoveAll(SnapshotDir(diffID)); err != nil {
+		return "", 0, fmt.Errorf("remove incomplete layer: %w", err)
+	}
+	if err := os.Rename(staging, SnapshotDir(diffID)); err != nil {
+		return "", 0, fmt.Errorf("commit layer: %w", err)
+	}
+	if err := writeSnapshotMarker(diffID); err != nil {
+		return "", 0, err
+	}
 
-	return digest, size, nil
+	return diffID, size, nil
 }
 
-// LayerExists checks if a layer with the given digest already exists.
-// Used to skip re-extraction of cached layers.
+// SnapshotExists checks if a complete snapshot with the given diffID exists.
+// Used to skip re-extraction of cached layers. A snapshot directory without a
+// completion marker is incomplete and does not count.
 //
 // Parameters:
-//   - digest: the "sha256:<hex>" identifier to check
+//   - diffID: the "sha256:<hex>" uncompressed layer digest to check
 //
 // Returns:
-//   - true if layer directory exists and is non-empty
-func LayerExists(digest string) bool {
-	// Get the path where this layer would be stored
-	path := LayerDir(digest)
-
+//   - true if the snapshot directory exists and is marked complete
+func SnapshotExists(diffID string) bool {
 	// Stat the path to check if it exists
 	// os.Stat follows symlinks; returns error if path doesn't exist
-	info, err := os.Stat(path)
-	if err != nil {
-		// Path doesn't exist or isn't accessible
+	info, err := os.Stat(SnapshotDir(diffID))
+	if err != nil || !info.IsDir() {
+		// Path doesn't exist, isn't accessible or isn't a directory
 		return false
 	}
 
-	// Verify it's actually a directory, not a file
-	return info.IsDir()
+	_, err = os.Stat(snapshotMarkerPath(diffID))
+	return err == nil
+}
+
+// touchSnapshot refreshes the completion marker of a snapshot that is about
+// to be reused, under the snapshot's lock, so `image prune` counts it as new
+// until the image using it is saved.
+// Returns false (and touches nothing) if there is no complete snapshot.
+func touchSnapshot(diffID string) (bool, error) {
+	unlock, err := lockSnapshot(diffID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if !SnapshotExists(diffID) {
+		return false, nil
+	}
+	now := time.Now()
+	if err := os.Chtimes(snapshotMarkerPath(diffID), now, now); err != nil {
+		return false, fmt.Errorf("refresh layer marker: %w", err)
+	}
+	return true, nil
+}
+
+// snapshotModTime returns when a snapshot was completed or last reused: the
+// mtime of its completion marker. The directory's own mtime comes from the
+// layer and says nothing about that; it is only used for incomplete snapshots.
+func snapshotModTime(diffID string) time.Time {
+	info, err := os.Stat(snapshotMarkerPath(diffID))
+	if err != nil {
+		if info, err = os.Lstat(SnapshotDir(diffID)); err != nil {
+			return time.Time{}
+		}
+	}
+	return info.ModTime()
+}
+
+// snapshotIncomplete reports whether a snapshot directory exists without
+// being marked complete: its extraction or removal was interrupted.
+func snapshotIncomplete(diffID string) bool {
+	_, err := os.Stat(SnapshotDir(diffID))
+	return err == nil && !SnapshotExists(diffID)
 }
 
-// RemoveLayer deletes a layer directory by its digest.
-// Called during image removal when layer is no longer referenced.
-// Uses os.RemoveAll to recursively delete the directory and all contents.
+// RemoveSnapshot deletes a snapshot directory by its diffID.
+// Called during image removal when the layer is no longer referenced.
+// The snapshot's lock is held, so a concurrent pull never sees it half-removed.
 //
 // Parameters:
-//   - digest: the "sha256:<hex>" identifier of the layer to remove
+//   - diffID: the "sha256:<hex>" uncompressed digest of the layer to remove
 //
 // Returns:
-//   - error if removal fails (nil if layer doesn't exist - idempotent)
-func RemoveLayer(digest string) error {
-	// Get the path where this layer is stored
-	path := LayerDir(digest)
+//   - error if removal fails (nil if the snapshot doesn't exist - idempotent)
+func RemoveSnapshot(diffID string) error {
+	unlock, err := lockSnapshot(diffID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return removeSnapshotLocked(diffID)
+}
+
+// removeSnapshotLocked deletes a snapshot; the caller must hold its lock.
+// The completion marker goes first, so an interrupted removal leaves an
+// incomplete snapshot behind rather than one that looks intact.
+func removeSnapshotLocked(diffID string) error {
+	if err := os.Remove(snapshotMarkerPath(diffID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove layer %s: %w", diffID, err)
+	}
 
 	// RemoveAll deletes path and any children it contains.
 	// Returns nil if path doesn't exist (idempotent operation).
-	// This is safe because LayerDir always returns a path under LayerBaseDir.
-	if err := os.RemoveAll(path); err != nil {
-		return fmt.Errorf("remove layer %s: %w", digest, err)
+	// This is safe because SnapshotDir always returns a path under SnapshotBaseDir.
+	if err := os.RemoveAll(SnapshotDir(diffID)); err != nil {
+		return fmt.Errorf("remove layer %s: %w", diffID, err)
 	}
 
 	return nil
 }
 
+// lockSnapshot takes an exclusive lock on a snapshot, waiting for other
+// processes (or goroutines) creating or removing it.
+// Returns the function releasing the lock.
+func lockSnapshot(diffID string) (func(), error) {
+	unlock, _, err := flockFile(lockPath(snapshotLockDir, diffID), unix.LOCK_EX)
+	return unlock, err
+}
+
+// tryLockSnapshot takes the lock on a snapshot if it is free.
+// Returns the function releasing the lock, or false if the snapshot is busy.
+func tryLockSnapshot(diffID string) (func(), bool, error) {
+	return flockFile(lockPath(snapshotLockDir, diffID), unix.LOCK_EX|unix.LOCK_NB)
+}
+
+// writeSnapshotMarker records that a snapshot is completely extracted.
+// The marker is written to a temporary file and renamed, so it is either
+// absent or complete.
+func writeSnapshotMarker(diffID string) error {
+	path := snapshotMarkerPath(diffID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, nil, 0o644); err != nil {
+		return fmt.Errorf("write layer marker: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write layer marker: %w", err)
+	}
+	return nil
+}
+
+// legacyMigratedPath marks a layer store of an older version as migrated.
+// Its directory keeps symlinks from the old layer paths, which containers
+// created before the migration recorded, to the snapshots.
+var legacyMigratedPath = filepath.Join(LayerBaseDir, ".migrated")
+
+// migrateLayerStore moves the layers of an older version's layer store,
+// keyed by compressed digest, to snapshots keyed by diffID. Each old layer
+// directory is replaced by a symlink to its snapshot, so containers created
+// before keep working. Images without recorded diffIDs get them from their
+// config, or by hashing the layer. Layers no image references and layers
+// left incomplete are not moved; `image prune` removes them.
+// Runs once per host; later calls only check for the migration marker.
+func migrateLayerStore() error {
+	if _, err := os.Stat(LayerBaseDir); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(legacyMigratedPath); err == nil {
+		return nil
+	}
+	unlock, _, err := flockFile(filepath.Join(LayerBaseDir, ".migrate.lock"), unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(legacyMigratedPath); err == nil {
+		return nil // Migrated by another process meanwhile
+	}
+
+	for _, dir := range []string{SnapshotStagingDir, snapshotLockDir, snapshotMarkerDir, filepath.Join(ContentBaseDir, "sha256")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	// Step 1: Map every referenced layer digest to its diffID
+	ids, err := imageIDs()
+	if err != nil {
+		return err
+	}
+	diffIDs := make(map[string]string)
+	for _, id := range ids {
+		meta, err := loadMetadataByID(id)
+		if err != nil {
+			continue // Unreadable metadata: nothing to migrate
+		}
+		if len(meta.DiffIDs) != len(meta.Layers) {
+			if meta.DiffIDs, err = legacyDiffIDs(meta); err != nil {
+				continue // Layers missing: the image cannot be repaired here
+			}
+			if err := SaveMetadata(meta); err != nil {
+				return err
+			}
+		}
+		for i, digest := range meta.Layers {
+			diffIDs[digest] = meta.DiffIDs[i]
+		}
+	}
+
+	// Step 2: Move each complete layer to its snapshot and link the old path to it.
+	// Layers extracted before completion markers existed count as complete.
+	_, statErr := os.Stat(filepath.Join(LayerBaseDir, ".complete"))
+	markers := statErr == nil
+	for digest, diffID := range diffIDs {
+		hex := strings.TrimPrefix(digest, "sha256:")
+		old := filepath.Join(LayerBaseDir, hex)
+		if info, err := os.Lstat(old); err != nil || !info.IsDir() {
+			continue // Missing, or already moved
+		}
+		if _, err := os.Stat(filepath.Join(LayerBaseDir, ".complete", hex)); markers && err != nil {
+			continue
+		}
+
+		if SnapshotExists(diffID) {
+			if err := os.RemoveAll(old); err != nil {
+				return err
+			}
+		} else {
+			if err := os.RemoveAll(SnapshotDir(diffID)); err != nil {
+				return err
+			}
+			if err := os.Rename(old, SnapshotDir(diffID)); err != nil {
+				return err
+			}
+			if err := writeSnapshotMarker(diffID); err != nil {
+				return err
+			}
+		}
+		if err := os.Symlink(SnapshotDir(diffID), old); err != nil {
+			return err
+		}
+	}
+
+	// Step 3: Keep interrupted downloads resumable in their new place
+	legacyDownloads := filepath.Join(LayerBaseDir, ".downloads")
+	if _, err := os.Stat(DownloadDir); os.IsNotExist(err) {
+		if err := os.Rename(legacyDownloads, DownloadDir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.WriteFile(legacyMigratedPath, nil, 0o644)
+}
+
+// legacyDiffIDs returns the diffIDs of an image stored without them: from
+// its config if it has one, otherwise by hashing each layer directory
+// archived as a tar (imports by older versions).
+func legacyDiffIDs(meta *ImageMetadata) ([]string, error) {
+	if config, err := LoadConfig(meta.ID); err == nil && len(config.RootFS.DiffIDs) == len(meta.Layers) {
+		return config.RootFS.DiffIDs, nil
+	}
+
+	diffIDs := make([]string, len(meta.Layers))
+	for i, digest := range meta.Layers {
+		diffID, err := writeUncompressedLayer(filepath.Join(LayerBaseDir, strings.TrimPrefix(digest, "sha256:")), io.Discard)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", shortDigest(digest), err)
+		}
+		diffIDs[i] = diffID
+	}
+	return diffIDs, nil
+}
+
 // computeDigest calculates the SHA256 hash of a file.
 // Returns the digest in "sha256:<hex>" format, matching OCI content-addressable
 // storage conventions (e.g., "sha256:a3ed95caeb02...").
@@ -170,39 +467,70 @@ func dirSize(path string) (int64, error) {
 }
 
 // extractTarball extracts a tar archive to the destination directory.
-// Supports both .tar and .tar.gz/.tgz files (auto-detected by tar command).
-// Uses the system tar command for simplicity and broad format support.
+// Supports plain, gzip and zstd compressed tarballs (see decompressStream).
+// OCI whiteouts are converted to overlayfs whiteouts by extractLayerTar.
 //
 // Parameters:
-//   - tarballPath: path to the .tar or .tar.gz file
+//   - tarballPath: path to the tarball
 //   - destDir: directory to extract contents into (must exist)
+//   - compression: compression of the tarball ("" to detect it)
 //
 // Returns:
+//   - diffID: the "sha256:<hex>" hash of the uncompressed tar stream
 //   - size: total bytes of extracted files
 //   - error: any error during extraction
-func extractTarball(tarballPath, destDir string) (int64, error) {
-	// Use system tar command with auto-compression detection (-a flag)
-	// -x: extract
-	// -f: read from file
-	// -C: change to directory before extracting
-	//
-	// Note: GNU tar auto-detects gzip compression, so we don't need -z flag
-	cmd := exec.Command("tar", "-xf", tarballPath, "-C", destDir)
+func extractTarball(tarballPath, destDir, compression string) (string, int64, error) {
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("open tarball: %w", err)
+	}
+	defer file.Close()
+
+	stream, err := decompressStream(file, compression)
+	if err != nil {
+		return "", 0, err
+	}
+	defer stream.Close()
 
-	// Capture stderr for error messages
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	// Hash the uncompressed stream while extracting it
+	hasher := sha256.New()
+	tee := io.TeeReader(stream, hasher)
+	if err := extractLayerTar(tee, destDir); err != nil {
+		return "", 0, err
+	}
 
-	// Run the extraction
-	if err := cmd.Run(); err != nil {
-		return 0, fmt.Errorf("tar extract failed: %v: %s", err, stderr.String())
+	// The tar reader stops at the end-of-archive marker; hash any trailing padding
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return "", 0, fmt.Errorf("read tarball: %w", err)
 	}
 
 	// Calculate total size of extracted files
 	size, err := dirSize(destDir)
 	if err != nil {
-		return 0, fmt.Errorf("calculate extracted size: %w", err)
+		return "", 0, fmt.Errorf("calculate extracted size: %w", err)
+	}
+
+	return fmt.Sprintf("sha256:%x", hasher.Sum(nil)), size, nil
+}
+
+// computeDiffID calculates the SHA256 of a tarball's uncompressed content.
+// Returns the diffID in "sha256:<hex>" format.
+func computeDiffID(tarballPath, compression string) (string, error) {
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return "", fmt.Errorf("open file for diffID: %w", err)
+	}
+	defer file.Close()
+
+	stream, err := decompressStream(file, compression)
+	if err != nil {
+		return "", err
 	}
+	defer stream.Close()
 
-	return size, nil
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, stream); err != nil {
+		return "", fmt.Errorf("hash uncompressed stream: %w", err)
+	}
+	return fmt.Sprintf("sha256:%x", hasher.Sum(nil)), nil
 }
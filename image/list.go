@@ -1,57 +1,61 @@
 package image
 
 import (
-	"os"
-	"path/filepath"
+	"sort"
 )
 
 // ListImages returns metadata for all locally stored images.
-// Scans the image directory structure: /var/lib/minicontainer/images/<name>/<tag>/
+// Tagged images are listed once per tag (sorted by reference), with Name and
+// Tag set; images without tags follow with empty Name and Tag.
 //
 // Returns:
 //   - slice of ImageMetadata for all found images
-//   - error if directory cannot be read
+//   - error if the image store cannot be read
 func ListImages() ([]*ImageMetadata, error) {
 	var images []*ImageMetadata
 
-	// Check if base directory exists
-	if _, err := os.Stat(ImageBaseDir); os.IsNotExist(err) {
-		// No images directory yet - return empty list
-		return images, nil
-	}
-
-	// Walk the images directory: images/<name>/<tag>/manifest.json
-	// First level: image names
-	names, err := os.ReadDir(ImageBaseDir)
+	repos, err := loadRepositories()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, nameEntry := range names {
-		if !nameEntry.IsDir() {
-			continue
-		}
-		namePath := filepath.Join(ImageBaseDir, nameEntry.Name())
+	// One entry per tag
+	refs := make([]string, 0, len(repos.Tags))
+	for ref := range repos.Tags {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
 
-		// Second level: tags for this image name
-		tags, err := os.ReadDir(namePath)
+	tagged := make(map[string]bool)
+	for _, ref := range refs {
+		id := repos.Tags[ref]
+		meta, err := loadMetadataByID(id)
 		if err != nil {
-			continue // Skip unreadable directories
+			continue // Skip tags pointing at missing/invalid metadata
 		}
+		parsed, err := ParseReference(ref)
+		if err != nil {
+			continue
+		}
+		meta.Name, meta.Tag = parsed.Name(), parsed.Tag
+		images = append(images, meta)
+		tagged[id] = true
+	}
 
-		for _, tagEntry := range tags {
-			if !tagEntry.IsDir() {
-				continue
-			}
-
-			// Load metadata for this name:tag
-			meta, err := LoadMetadata(nameEntry.Name(), tagEntry.Name())
-			if err != nil {
-				continue // Skip images with invalid/missing metadata
-			}
-
-			images = append(images, meta)
+	// Untagged images (e.g. replaced by a newer pull or build of the same tag)
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if tagged[id] {
+			continue
+		}
+		meta, err := loadMetadataByID(id)
+		if err != nil {
+			continue
 		}
+		images = append(images, meta)
 	}
 
 	return images, nil
@@ -0,0 +1,407 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Load imports images from a `docker save` archive or an OCI image layout
+// (tarball or directory), keeping their layers and config.
+// The format is detected from the archive contents: index.json marks an OCI
+// layout, manifest.json a docker archive. Tarballs may be gzip-compressed.
+//
+// Parameters:
+//   - input: path to the archive or OCI layout directory
+//
+// Returns:
+//   - []*ImageMetadata: one entry per loaded name:tag
+//   - error: any error during load
+func Load(input string) ([]*ImageMetadata, error) {
+	// Step 1: Ensure base directories exist
+	if err := EnsureImageDirs(); err != nil {
+		return nil, fmt.Errorf("ensure image dirs: %w", err)
+	}
+
+	// Step 2: Unpack tarballs so their files can be read in any order
+	root := input
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	if !info.IsDir() {
+		root, err = os.MkdirTemp(DownloadDir, "load-*")
+		if err != nil {
+			return nil, fmt.Errorf("create temp dir: %w", err)
+		}
+		defer os.RemoveAll(root)
+
+		if err := unpackArchive(input, root); err != nil {
+			return nil, err
+		}
+	}
+
+	// Step 3: Load in the detected format
+	if _, err := os.Stat(filepath.Join(root, "index.json")); err == nil {
+		return loadOCI(root)
+	}
+	if _, err := os.Stat(filepath.Join(root, "manifest.json")); err == nil {
+		return loadDocker(root)
+	}
+	return nil, fmt.Errorf("%s is not a docker archive or OCI image layout", input)
+}
+
+// loadDocker loads every image listed in a docker archive's manifest.json.
+func loadDocker(root string) ([]*ImageMetadata, error) {
+	data, err := readArchiveFile(root, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var entries []dockerArchiveManifest
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse manifest.json: %w", err)
+	}
+
+	var loaded []*ImageMetadata
+	for _, entry := range entries {
+		configBlob, err := readArchiveFile(root, entry.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		layerPaths := make([]string, len(entry.Layers))
+		for i, layer := range entry.Layers {
+			if layerPaths[i], err = resolveInRoot(root, filepath.Clean(layer)); err != nil {
+				return nil, err
+			}
+		}
+
+		metas, err := storeLoadedImage(configBlob, nil, layerPaths, nil, entry.RepoTags)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, metas...)
+	}
+	return loaded, nil
+}
+
+// loadOCI loads every tagged manifest listed in an OCI layout's index.json.
+// Entries that are themselves indexes (multi-arch images) are resolved to
+// the manifest for the host platform.
+func loadOCI(root string) ([]*ImageMetadata, error) {
+	data, err := readArchiveFile(root, "index.json")
+	if err != nil {
+		return nil, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("parse index.json: %w", err)
+	}
+
+	var loaded []*ImageMetadata
+	for _, desc := range index.Manifests {
+		// Untagged manifests are loaded as untagged images
+		ref := ociRefName(desc.Annotations)
+		var repoTags []string
+		if ref != "" {
+			repoTags = []string{ref}
+		}
+
+		// Step 1: Resolve multi-arch indexes to the host platform's manifest
+		manifestBlob, err := readBlob(root, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		manifestType := desc.MediaType
+		if isIndexMediaType(desc.MediaType) {
+			var list ManifestList
+			if err := json.Unmarshal(manifestBlob, &list); err != nil {
+				return nil, fmt.Errorf("parse index %s: %w", shortDigest(desc.Digest), err)
+			}
+			if err := validateIndex(&list, desc.MediaType); err != nil {
+				return nil, fmt.Errorf("index %s: %w", shortDigest(desc.Digest), err)
+			}
+			digest, _, ok := selectPlatform(&list, HostPlatform())
+			if !ok {
+				return nil, fmt.Errorf("%s: no manifest found for platform %s", shortDigest(desc.Digest), HostPlatform())
+			}
+			if manifestBlob, err = readBlob(root, digest); err != nil {
+				return nil, err
+			}
+			manifestType = ""
+			for _, m := range list.Manifests {
+				if m.Digest == digest {
+					manifestType = m.MediaType
+				}
+			}
+		}
+
+		var manifest ManifestV2
+		if err := json.Unmarshal(manifestBlob, &manifest); err != nil {
+			return nil, fmt.Errorf("parse manifest: %w", err)
+		}
+		if err := validateManifest(&manifest, manifestType); err != nil {
+			return nil, err
+		}
+
+		// Step 2: Read the config and locate the layer blobs
+		configBlob, err := readBlob(root, manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+		layerPaths := make([]string, len(manifest.Layers))
+		for i, layer := range manifest.Layers {
+			if layerPaths[i], err = blobFile(root, layer.Digest); err != nil {
+				return nil, err
+			}
+			// Non-distributable layers are commonly left out of layouts
+			if lt, _ := parseLayerMediaType(layer.MediaType); lt.foreign {
+				if _, err := os.Stat(layerPaths[i]); err != nil {
+					return nil, fmt.Errorf("non-distributable layer %s is not included in the archive", shortDigest(layer.Digest))
+				}
+			}
+		}
+
+		metas, err := storeLoadedImage(configBlob, manifestBlob, layerPaths, manifest.Layers, repoTags)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, metas...)
+	}
+	return loaded, nil
+}
+
+// storeLoadedImage extracts an image's layers and saves its metadata and
+// config, tagged with each of repoTags. The config and manifest are added to
+// the content store as they are, so the image saves and pushes unchanged.
+//
+// Parameters:
+//   - configBlob: raw image config
+//   - manifestBlob: raw image manifest (nil for docker archives, which have none)
+//   - layerPaths: layer tarballs, bottom to top
+//   - layers: manifest descriptors of the layers, giving the expected blob
+//     digests and media types (nil for docker archives: no digest check,
+//     compression detected from the content)
+//   - repoTags: "name:tag" references to tag the image with (none: untagged)
+//
+// Returns the metadata of each stored reference (one untagged entry if there are none).
+func storeLoadedImage(configBlob, manifestBlob []byte, layerPaths []string, layers []Descriptor, repoTags []string) ([]*ImageMetadata, error) {
+	var config ImageConfig
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if len(config.RootFS.DiffIDs) != len(layerPaths) {
+		return nil, fmt.Errorf("config lists %d diff_ids but image has %d layers",
+			len(config.RootFS.DiffIDs), len(layerPaths))
+	}
+	if err := validateDiffIDs(config.RootFS.DiffIDs); err != nil {
+		return nil, err
+	}
+	configDigest := digestBytes(configBlob)
+
+	// Validate the tags before extracting anything
+	var refs []*ImageReference
+	for _, repoTag := range repoTags {
+		parsed, err := ParseTagReference(repoTag)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, &parsed)
+	}
+	if len(refs) == 0 {
+		refs = []*ImageReference{nil} // Stored untagged
+	}
+
+	// Step 1: Extract layers, verifying blob digests and diffIDs
+	var totalSize int64
+	digests := make([]string, len(layerPaths))
+	for i, path := range layerPaths {
+		var mediaType string
+		if layers != nil {
+			mediaType = layers[i].MediaType
+		}
+		digest, _, size, err := ExtractLayer(path, mediaType, config.RootFS.DiffIDs[i])
+		if err != nil {
+			return nil, fmt.Errorf("extract layer %d: %w", i+1, err)
+		}
+		if layers != nil && digest != layers[i].Digest {
+			return nil, fmt.Errorf("layer %s: digest mismatch: got %s", shortDigest(layers[i].Digest), digest)
+		}
+		fmt.Printf("  %s: Loaded\n", shortDigest(digest))
+		digests[i] = digest
+		totalSize += size
+	}
+
+	// Step 2: Save the config and manifest once, then the metadata under every tag
+	id := strings.TrimPrefix(configDigest, "sha256:")
+	if _, err := writeContent(configBlob); err != nil {
+		return nil, err
+	}
+	var manifestDigest string
+	if manifestBlob != nil {
+		digest, err := writeContent(manifestBlob)
+		if err != nil {
+			return nil, err
+		}
+		manifestDigest = digest
+	}
+	if err := SaveConfig(id, &config); err != nil {
+		return nil, fmt.Errorf("save config: %w", err)
+	}
+	platform := Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+	var metas []*ImageMetadata
+	for _, ref := range refs {
+		meta := &ImageMetadata{
+			ID:             id,
+			Layers:         digests,
+			DiffIDs:        config.RootFS.DiffIDs,
+			ConfigDigest:   configDigest,
+			ManifestDigest: manifestDigest,
+			CreatedAt:      time.Now(),
+			Size:           totalSize,
+		}
+		if ref != nil {
+			meta.Name, meta.Tag = ref.Name(), ref.Tag
+		}
+		if platform.OS != "" {
+			meta.Platform = platform.String()
+		}
+		if err := SaveMetadata(meta); err != nil {
+			return nil, fmt.Errorf("save metadata: %w", err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// ociRefName returns the "name:tag" reference recorded in an index entry's
+// annotations. A bare tag in org.opencontainers.image.ref.name carries no
+// image name and cannot be stored, so it yields "".
+func ociRefName(annotations map[string]string) string {
+	if ref := annotations[annotationImageName]; ref != "" {
+		return ref
+	}
+	ref := annotations[annotationRefName]
+	if strings.ContainsAny(ref, ":/") {
+		return ref
+	}
+	return ""
+}
+
+// readBlob reads a blob from an OCI layout and verifies its digest.
+func readBlob(root, digest string) ([]byte, error) {
+	path, err := blobFile(root, digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s: %w", shortDigest(digest), err)
+	}
+	if got := digestBytes(data); got != digest {
+		return nil, fmt.Errorf("blob %s: digest mismatch: got %s", shortDigest(digest), got)
+	}
+	return data, nil
+}
+
+// blobFile returns the path of a blob in an OCI layout.
+func blobFile(root, digest string) (string, error) {
+	if _, err := newDigestVerifier(digest); err != nil {
+		return "", err
+	}
+	return resolveInRoot(root, layoutBlobPath(digest))
+}
+
+// readArchiveFile reads a file from an unpacked archive without following
+// symlinks out of it.
+func readArchiveFile(root, name string) ([]byte, error) {
+	path, err := resolveInRoot(root, filepath.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// unpackArchive extracts the regular files, directories and symlinks of an
+// image archive into destDir. Docker archives link shared layers with symlinks.
+func unpackArchive(path, destDir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer file.Close()
+
+	stream, err := decompressStream(file, "")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+
+		name, err := cleanEntryName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+		parent, err := resolveInRoot(destDir, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", filepath.Dir(name), err)
+		}
+		target := filepath.Join(parent, filepath.Base(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("create %s: %w", name, err)
+			}
+		case tar.TypeReg:
+			// Replace whatever an earlier entry put there instead of writing
+			// through it: a symlink there could point anywhere on the host
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("replace %s: %w", name, err)
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|unix.O_NOFOLLOW, 0o644)
+			if err != nil {
+				return fmt.Errorf("create %s: %w", name, err)
+			}
+			_, err = io.Copy(out, tr)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("write %s: %w", name, err)
+			}
+		case tar.TypeSymlink:
+			// Resolved with resolveInRoot when read, so links out of destDir are
+			// refused; regular files are never written through them
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("create %s: %w", name, err)
+			}
+		}
+	}
+}
@@ -5,43 +5,66 @@ import (
 	"os"
 )
 
-// LookupImage finds an image by reference and returns the path to its root layer.
-// For single-layer images (imports), this returns the layer directory directly.
-// For multi-layer images (pulled), this would need to set up overlayfs (future).
+// LookupImage finds an image by reference and returns its metadata and the
+// paths to its layers. The reference is resolved once, so the layers always
+// belong to the returned image even if its tag moves meanwhile; everything
+// else about the image (config, signatures) must be read from the metadata.
+// The layers are returned bottom to top, in the same order as ImageMetadata.DiffIDs,
+// ready to be stacked as overlayfs lower directories.
 //
 // Parameters:
-//   - ref: image reference in "name" or "name:tag" format
+//   - ref: image reference in "name" or "name:tag" format, or an image ID
 //
 // Returns:
-//   - rootfsPath: path to the layer directory to use as container rootfs
-//   - error: if image not found or has no layers
-func LookupImage(ref string) (rootfsPath string, err error) {
-	// Parse the image reference
-	name, tag := ParseImageRef(ref)
-
-	// Load image metadata
-	meta, err := LoadMetadata(name, tag)
+//   - meta: the image metadata
+//   - layerPaths: extracted layer directories, bottom to top
+//   - error: if image not found, has no layers, or a layer is missing
+func LookupImage(ref string) (meta *ImageMetadata, layerPaths []string, err error) {
+	// Load image metadata by tag or ID
+	meta, err = ResolveImage(ref)
 	if err != nil {
-		// Check if it's a "not found" error
-		if os.IsNotExist(err) {
-			return "", fmt.Errorf("image %s:%s not found", name, tag)
-		}
-		return "", fmt.Errorf("load image metadata: %w", err)
+		return nil, nil, err
 	}
 
 	// Verify image has at least one layer
 	if len(meta.Layers) == 0 {
-		return "", fmt.Errorf("image %s:%s has no layers", name, tag)
+		return nil, nil, fmt.Errorf("image %s has no layers", ref)
+	}
+	if len(meta.DiffIDs) != len(meta.Layers) {
+		return nil, nil, fmt.Errorf("image %s has no layer diff_ids, pull or import it again", ref)
 	}
 
-	// For now, use the first (and typically only) layer as rootfs
-	// Multi-layer support would require stacking layers with overlayfs
-	rootfsPath = LayerDir(meta.Layers[0])
-
-	// Verify the layer exists
-	if !LayerExists(meta.Layers[0]) {
-		return "", fmt.Errorf("layer %s not found for image %s:%s", meta.Layers[0][:12], name, tag)
+	// Resolve every layer to its snapshot, verifying each one exists
+	for i, diffID := range meta.DiffIDs {
+		if snapshotIncomplete(diffID) {
+			return nil, nil, fmt.Errorf("layer %s of image %s is incomplete (interrupted extraction): pull or load the image again to repair it",
+				shortDigest(meta.Layers[i]), ref)
+		}
+		if !SnapshotExists(diffID) {
+			return nil, nil, fmt.Errorf("layer %s not found for image %s", shortDigest(meta.Layers[i]), ref)
+		}
+		layerPaths = append(layerPaths, SnapshotDir(diffID))
 	}
 
-	return rootfsPath, nil
+	return meta, layerPaths, nil
+}
+
+// LookupConfig returns the stored runtime config of the image with the given ID.
+// Imported images have no config; for those it returns nil without error.
+//
+// Parameters:
+//   - id: full image ID (ImageMetadata.ID)
+//
+// Returns:
+//   - *ImageConfig: the image config, or nil if the image has none
+//   - error: if the config exists but cannot be read
+func LookupConfig(id string) (*ImageConfig, error) {
+	config, err := LoadConfig(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("load image config: %w", err)
+	}
+	return config, nil
 }
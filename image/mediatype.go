@@ -0,0 +1,207 @@
+package image
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// Docker image manifest v2 schema 2 media types.
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar"
+	MediaTypeDockerLayerGzip    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+// OCI image-spec media types.
+const (
+	MediaTypeOCIIndex                     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest                  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIConfig                    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCIEmpty                     = "application/vnd.oci.empty.v1+json" // "{}" config of artifacts
+	MediaTypeOCILayer                     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip                 = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd                 = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeOCINonDistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCINonDistributableLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeOCINonDistributableLayerZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+)
+
+// manifestMediaTypes is the Accept list for manifest requests, indexes first
+// so a registry serving a multi-arch image returns the index to pick from.
+var manifestMediaTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifest,
+}
+
+// Layer compression formats.
+const (
+	compressionNone = "none" // Plain tar
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// layerType describes how a layer blob of a given media type is stored.
+type layerType struct {
+	compression string // compressionNone, compressionGzip or compressionZstd
+	foreign     bool   // Non-distributable: registries may only serve it from the descriptor's URLs
+}
+
+// layerTypes maps each supported layer media type to its layer type.
+var layerTypes = map[string]layerType{
+	MediaTypeDockerLayer:                  {compression: compressionNone},
+	MediaTypeDockerLayerGzip:              {compression: compressionGzip},
+	MediaTypeDockerForeignLayer:           {compression: compressionGzip, foreign: true},
+	MediaTypeOCILayer:                     {compression: compressionNone},
+	MediaTypeOCILayerGzip:                 {compression: compressionGzip},
+	MediaTypeOCILayerZstd:                 {compression: compressionZstd},
+	MediaTypeOCINonDistributableLayer:     {compression: compressionNone, foreign: true},
+	MediaTypeOCINonDistributableLayerGzip: {compression: compressionGzip, foreign: true},
+	MediaTypeOCINonDistributableLayerZstd: {compression: compressionZstd, foreign: true},
+}
+
+// parseLayerMediaType returns the layer type of a layer media type.
+// Returns an error for media types that are not image layers, e.g. Helm
+// charts or other OCI artifacts stored in a registry.
+func parseLayerMediaType(mediaType string) (layerType, error) {
+	lt, ok := layerTypes[mediaType]
+	if !ok {
+		return layerType{}, fmt.Errorf("unsupported layer media type %q", mediaType)
+	}
+	return lt, nil
+}
+
+// layerCompression returns the compression of a layer media type, or ""
+// (detect from the content) if the media type is unknown because the layer
+// did not come with a descriptor.
+func layerCompression(mediaType string) (string, error) {
+	if mediaType == "" {
+		return "", nil
+	}
+	lt, err := parseLayerMediaType(mediaType)
+	if err != nil {
+		return "", err
+	}
+	return lt.compression, nil
+}
+
+// isIndexMediaType reports whether mediaType is a multi-platform index.
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// isManifestMediaType reports whether mediaType is a single-platform image manifest.
+func isManifestMediaType(mediaType string) bool {
+	return mediaType == MediaTypeOCIManifest || mediaType == MediaTypeDockerManifest
+}
+
+// contentMediaType returns the media type of a Content-Type header value,
+// without parameters such as "; charset=utf-8".
+func contentMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.TrimSpace(contentType)
+	}
+	return mediaType
+}
+
+// documentMediaType determines the media type of a manifest or index.
+// The document's own mediaType field wins; OCI allows omitting it, in which
+// case the Content-Type (or descriptor media type) it was served with is used.
+// Generic content types such as "application/json" say nothing and are ignored.
+//
+// Parameters:
+//   - field: the document's mediaType field ("" if omitted)
+//   - served: the Content-Type or descriptor media type ("" if unknown)
+//
+// Returns the media type ("" if unknown), or an error if the two disagree.
+func documentMediaType(field, served string) (string, error) {
+	served = contentMediaType(served)
+	if !isIndexMediaType(served) && !isManifestMediaType(served) {
+		served = ""
+	}
+	if field != "" && served != "" && field != served {
+		return "", fmt.Errorf("manifest media type %s does not match content type %s", field, served)
+	}
+	if field != "" {
+		return field, nil
+	}
+	return served, nil
+}
+
+// validateIndex checks a manifest list or OCI index before a platform is
+// selected from it.
+//
+// Parameters:
+//   - list: the parsed index
+//   - served: the Content-Type or descriptor media type it was served with
+func validateIndex(list *ManifestList, served string) error {
+	if list.SchemaVersion != 2 {
+		return fmt.Errorf("unsupported index schema version %d", list.SchemaVersion)
+	}
+	mediaType, err := documentMediaType(list.MediaType, served)
+	if err != nil {
+		return err
+	}
+	if mediaType != "" && !isIndexMediaType(mediaType) {
+		return fmt.Errorf("unsupported index media type %q", mediaType)
+	}
+	return nil
+}
+
+// validateManifest checks an image manifest before any of its blobs are
+// fetched: the schema version, its own media type, that the config is an
+// image config (and not some other OCI artifact) and that every layer has
+// a supported media type. Blob digests name files in the image store, so
+// they must be well-formed before anything is downloaded.
+//
+// Parameters:
+//   - manifest: the parsed manifest
+//   - served: the Content-Type or descriptor media type it was served with
+func validateManifest(manifest *ManifestV2, served string) error {
+	if manifest.SchemaVersion != 2 {
+		return fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	mediaType, err := documentMediaType(manifest.MediaType, served)
+	if err != nil {
+		return err
+	}
+	if mediaType != "" && !isManifestMediaType(mediaType) {
+		return fmt.Errorf("unsupported manifest media type %q", mediaType)
+	}
+
+	switch manifest.Config.MediaType {
+	case MediaTypeDockerConfig, MediaTypeOCIConfig:
+	default:
+		return fmt.Errorf("unsupported config media type %q: not a container image", manifest.Config.MediaType)
+	}
+	if err := ValidateDigest(manifest.Config.Digest); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	for i, layer := range manifest.Layers {
+		if err := ValidateDigest(layer.Digest); err != nil {
+			return fmt.Errorf("layer %d: %w", i+1, err)
+		}
+		if _, err := parseLayerMediaType(layer.MediaType); err != nil {
+			return fmt.Errorf("layer %s: %w", shortDigest(layer.Digest), err)
+		}
+	}
+	return nil
+}
+
+// validateDiffIDs checks the rootfs.diff_ids of an image config, which name
+// snapshot directories, before any layer is extracted.
+func validateDiffIDs(diffIDs []string) error {
+	for i, diffID := range diffIDs {
+		if err := ValidateDigest(diffID); err != nil {
+			return fmt.Errorf("config diff_id %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
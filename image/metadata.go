@@ -5,26 +5,52 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
+// ImageMetadata describes a locally stored image.
+// It is stored once per image ID; Name and Tag are not part of the stored
+// record but hold the reference the metadata was loaded through.
 type ImageMetadata struct {
-	ID           string    `json:"id"`            // SHA256 hash of image content (64 hex chars)
-	Name         string    `json:"name"`          // Image name (e.g., "alpine")
-	Tag          string    `json:"tag"`           // Image tag (e.g., "latest")
-	Layers       []string  `json:"layers"`        // Layer digests in order (bottom to top)
-	ConfigDigest string    `json:"config_digest"` // Digest of config blob (for registry images, empty for imports)
-	CreatedAt    time.Time `json:"created_at"`    // When image was created/imported
-	Size         int64     `json:"size"`          // Total size in bytes
+	ID             string      `json:"id"`                        // SHA256 hash of image content (64 hex chars)
+	Name           string      `json:"-"`                         // Image name the image was looked up by (e.g., "alpine")
+	Tag            string      `json:"-"`                         // Image tag the image was looked up by (e.g., "latest")
+	Layers         []string    `json:"layers"`                    // Layer blob digests in order (bottom to top)
+	DiffIDs        []string    `json:"diff_ids"`                  // Uncompressed layer digests (snapshot keys), matching config rootfs.diff_ids
+	ConfigDigest   string      `json:"config_digest"`             // Digest of config blob (empty for imports)
+	ManifestDigest string      `json:"manifest_digest,omitempty"` // Digest of the original manifest in the content store (pulled and loaded images)
+	RepoDigests    []string    `json:"repo_digests,omitempty"`    // "name@sha256:..." manifest digests the image was pulled or pushed as
+	Signatures     []Signature `json:"signatures,omitempty"`      // Signatures fetched from the registry at pull, verified again by run
+	Platform       string      `json:"platform"`                  // Resolved platform "os/arch[/variant]" (empty for imports)
+	CreatedAt      time.Time   `json:"created_at"`                // When image was created/imported
+	Size           int64       `json:"size"`                      // Total size in bytes
 }
 
-// SaveMetadata writes image metadata to manifest.json in the image directory.
+// SaveMetadata writes image metadata to manifest.json in the image's directory
+// and, if meta.Name is set, points the tag meta.Name:meta.Tag at the image.
+// An image the tag pointed to before keeps its other tags (or becomes untagged).
+// Repo digests and signatures already recorded for the image are kept.
 func SaveMetadata(meta *ImageMetadata) error {
-	dir := ImageDir(meta.Name, meta.Tag)
+	dir := ImageDir(meta.ID)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("create image dir: %w", err)
 	}
 
+	if old, err := loadMetadataByID(meta.ID); err == nil {
+		for _, repoDigest := range old.RepoDigests {
+			name, digest, _ := strings.Cut(repoDigest, "@")
+			meta.AddRepoDigest(name, digest)
+		}
+		// Signatures fetched just now win over older ones by the same key
+		for _, sig := range old.Signatures {
+			if !slices.ContainsFunc(meta.Signatures, sig.sameSigner) {
+				meta.Signatures = append(meta.Signatures, sig)
+			}
+		}
+	}
+
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal metadata: %w", err)
@@ -34,12 +60,33 @@ func SaveMetadata(meta *ImageMetadata) error {
 	if err := os.WriteFile(path, data, 0o644); err != nil {
 		return fmt.Errorf("write manifest: %w", err)
 	}
-	return nil
+
+	if meta.Name == "" {
+		return nil
+	}
+	return setTag(meta.Name, meta.Tag, meta.ID)
 }
 
-// LoadMetadata reads image metadata from manifest.json.
+// LoadMetadata reads the metadata of the image tagged name:tag.
+// Returns an error wrapping os.ErrNotExist if the tag does not exist.
 func LoadMetadata(name, tag string) (*ImageMetadata, error) {
-	path := filepath.Join(ImageDir(name, tag), "manifest.json")
+	id, err := resolveTag(name, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := loadMetadataByID(id)
+	if err != nil {
+		return nil, err
+	}
+	meta.Name, meta.Tag = name, tag
+	return meta, nil
+}
+
+// loadMetadataByID reads an image's metadata by its full ID.
+// The returned metadata has no Name or Tag.
+func loadMetadataByID(id string) (*ImageMetadata, error) {
+	path := filepath.Join(ImageDir(id), "manifest.json")
 
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -52,3 +99,94 @@ func LoadMetadata(name, tag string) (*ImageMetadata, error) {
 	}
 	return &meta, nil
 }
+
+// references returns the digests of everything the image references in the
+// content store and the snapshots: its manifest, config, layer blobs and
+// snapshots. Content that is not stored is included as well.
+func (m *ImageMetadata) references() []string {
+	refs := append(append([]string{}, m.Layers...), m.DiffIDs...)
+	for _, digest := range []string{m.ConfigDigest, m.ManifestDigest} {
+		if digest != "" {
+			refs = append(refs, digest)
+		}
+	}
+	return refs
+}
+
+// AddRepoDigest records that the image is available from repository name
+// under the manifest digest. Duplicates are ignored.
+func (m *ImageMetadata) AddRepoDigest(name, digest string) {
+	repoDigest := name + "@" + digest
+	if !slices.Contains(m.RepoDigests, repoDigest) {
+		m.RepoDigests = append(m.RepoDigests, repoDigest)
+	}
+}
+
+// RepoDigest returns the manifest digest recorded for repository name,
+// or "" if the image was never pulled or pushed under that name.
+func (m *ImageMetadata) RepoDigest(name string) string {
+	for _, repoDigest := range m.RepoDigests {
+		if repoName, digest, _ := strings.Cut(repoDigest, "@"); repoName == name {
+			return digest
+		}
+	}
+	return ""
+}
+
+// AddSignature records a signature of the image's manifest. A signature by
+// the same key over the same digest replaces the old one.
+func (m *ImageMetadata) AddSignature(sig Signature) {
+	m.Signatures = addSignature(m.Signatures, sig)
+}
+
+// manifestDigests returns the digests the image's manifest is known by:
+// the stored manifest and every repo digest (which may be an index).
+func (m *ImageMetadata) manifestDigests() []string {
+	var digests []string
+	if m.ManifestDigest != "" {
+		digests = append(digests, m.ManifestDigest)
+	}
+	for _, repoDigest := range m.RepoDigests {
+		if _, digest, _ := strings.Cut(repoDigest, "@"); !slices.Contains(digests, digest) {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
+
+// SaveConfig writes the image runtime config to config.json in the image directory.
+// Stored next to manifest.json so `run` can apply Entrypoint, Cmd, Env, etc.
+func SaveConfig(id string, config *ImageConfig) error {
+	dir := ImageDir(id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create image dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config: %w", err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// LoadConfig reads the image runtime config from config.json.
+// Returns an os.IsNotExist error for images without a config (e.g., imports).
+func LoadConfig(id string) (*ImageConfig, error) {
+	path := filepath.Join(ImageDir(id), "config.json")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	return &config, nil
+}
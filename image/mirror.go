@@ -0,0 +1,123 @@
+package image
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+// UseMirrors routes the client's pulls through the mirrors configured for
+// its registry (see RegistriesConfig.Mirrors).
+// Manifests and blobs are then requested from each mirror in order and from
+// the registry itself last: a mirror that cannot be reached or authenticated
+// with, or that answers with anything but a success or a redirect, is skipped. Every endpoint authenticates on
+// first use, so Authenticate must not be called beforehand; a registry that
+// is unreachable from this host can be pulled from as long as a mirror
+// serves everything.
+//
+// Content is verified against its digest wherever it comes from, but a
+// mirror may serve an outdated manifest for a tag, like any pull-through cache.
+// Push, login and sign always talk to the registry itself.
+//
+// Returns the mirrors in the order they are tried (none if nothing is configured).
+func (c *RegistryClient) UseMirrors() ([]string, error) {
+	cfg, err := LoadRegistriesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, host := range cfg.MirrorsFor(c.ref.Registry) {
+		if host == c.ref.Registry {
+			continue
+		}
+		ref := c.ref
+		ref.Registry = host
+		mirror, err := NewRegistryClient(ref)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %s: %w", host, err)
+		}
+		c.mirrors = append(c.mirrors, mirror)
+		hosts = append(hosts, host)
+	}
+	if len(c.mirrors) > 0 {
+		c.served = make(map[string]string)
+	}
+	return hosts, nil
+}
+
+// ServedBy returns the registry, mirror or URL host a manifest or blob with
+// digest was fetched from. Only recorded when mirrors are in use; empty otherwise.
+func (c *RegistryClient) ServedBy(digest string) string {
+	c.servedMu.Lock()
+	defer c.servedMu.Unlock()
+	return c.served[digest]
+}
+
+// recordServed remembers where the content with digest came from.
+func (c *RegistryClient) recordServed(digest, source string) {
+	c.servedMu.Lock()
+	defer c.servedMu.Unlock()
+	if c.served != nil {
+		c.served[digest] = source
+	}
+}
+
+// fetch sends a GET request for a manifest or blob, built against
+// c.endpoint, to the mirrors and then the registry.
+// Without mirrors it is the same as do.
+//
+// Returns:
+//   - *http.Response: the first mirror response that is a success or a
+//     redirect, or else the registry's own response, whatever its status
+//   - string: the registry or mirror that sent it
+//   - error: if the registry cannot be reached either; what went wrong with
+//     each mirror is included
+func (c *RegistryClient) fetch(req *http.Request) (*http.Response, string, error) {
+	if len(c.mirrors) == 0 {
+		resp, err := c.do(req)
+		return resp, c.ref.Registry, err
+	}
+
+	var skipped []string
+	for _, mirror := range c.mirrors {
+		resp, err := mirror.fetchDirect(req)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 400 {
+			return resp, mirror.ref.Registry, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		skipped = append(skipped, fmt.Sprintf("%s: %v", mirror.ref.Registry, err))
+	}
+
+	resp, err := c.fetchDirect(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w (mirrors skipped: %s)", err, strings.Join(skipped, "; "))
+	}
+	return resp, c.ref.Registry, nil
+}
+
+// fetchDirect sends a copy of req to this client's own endpoint,
+// authenticating first if it has not been done yet.
+func (c *RegistryClient) fetchDirect(req *http.Request) (*http.Response, error) {
+	c.authOnce.Do(func() {
+		if err := c.Authenticate(); err != nil {
+			c.authErr = fmt.Errorf("authenticate: %w", err)
+		}
+	})
+	if c.authErr != nil {
+		return nil, c.authErr
+	}
+
+	// The endpoint is only final once authenticated (HTTP fallback)
+	u, err := neturl.Parse(c.endpoint + req.URL.RequestURI())
+	if err != nil {
+		return nil, fmt.Errorf("build request URL: %w", err)
+	}
+	direct := req.Clone(req.Context())
+	direct.URL, direct.Host = u, u.Host
+	return c.do(direct)
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform identifies the OS and CPU architecture an image was built for.
+// Matches the "platform" object of OCI image indexes and Docker manifest lists.
+type Platform struct {
+	OS           string // e.g., "linux"
+	Architecture string // e.g., "amd64", "arm64", "arm"
+	Variant      string // e.g., "v7" for arm, "v8" for arm64 (optional)
+}
+
+// HostPlatform returns the platform of the machine we are running on.
+// Used as the default when no --platform is given.
+func HostPlatform() Platform {
+	p := Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	switch p.Architecture {
+	case "arm64":
+		p.Variant = "v8"
+	case "arm":
+		p.Variant = "v7"
+	}
+	return p
+}
+
+// ParsePlatform parses a platform string in "os/arch[/variant]" format.
+//
+// Examples:
+//   - "linux/amd64"    -> {linux, amd64, ""}
+//   - "linux/arm/v7"   -> {linux, arm, v7}
+//   - "linux/arm64/v8" -> {linux, arm64, v8}
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(strings.ToLower(s), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", s)
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// String returns the platform in "os/arch[/variant]" format.
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Matches reports whether an image built for other can run on platform p.
+// OS and architecture must be equal. The variant must match when both sides
+// specify one; arm64 treats an empty variant as "v8" (the only arm64 variant
+// registries publish without a variant field).
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+
+	want, have := normalizeVariant(p), normalizeVariant(other)
+	return want == "" || have == "" || want == have
+}
+
+// normalizeVariant fills in the implied variant for architectures that have one.
+func normalizeVariant(p Platform) string {
+	if p.Architecture == "arm64" && p.Variant == "" {
+		return "v8"
+	}
+	return p.Variant
+}
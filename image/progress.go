@@ -0,0 +1,235 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Layer progress states shown in the pull and push output.
+const (
+	statusWaiting     = "Waiting"
+	statusDownloading = "Downloading"
+	statusResuming    = "Resuming"
+	statusVerifying   = "Verifying"
+	statusExtracting  = "Extracting"
+	statusComplete    = "Pull complete"
+	statusExists      = "Already exists"
+	statusFailed      = "Failed"
+
+	statusPreparing   = "Preparing"
+	statusPushing     = "Pushing"
+	statusPushed      = "Pushed"
+	statusLayerExists = "Layer already exists"
+	statusForeign     = "Skipped foreign layer"
+)
+
+// progressBarWidth is the number of cells in the download bar.
+const progressBarWidth = 30
+
+// layerProgress tracks the download state of a single layer.
+// It implements io.Writer so it can be placed in the download copy chain.
+type layerProgress struct {
+	mu      sync.Mutex
+	id      string    // Short digest shown as the line label
+	status  string    // One of the status* constants
+	current int64     // Bytes downloaded so far (including resumed bytes)
+	total   int64     // Expected blob size (0 if unknown)
+	resumed int64     // Bytes already present when this download started
+	start   time.Time // When the current transfer started (for rate/ETA)
+	source  string    // Mirror or registry the layer comes from (only shown with mirrors)
+	display *progressDisplay
+}
+
+// Write implements io.Writer by counting transferred bytes.
+func (p *layerProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.current += int64(len(b))
+	p.mu.Unlock()
+	return len(b), nil
+}
+
+// SetStatus changes the layer's status. Non-terminal output prints a line per change.
+func (p *layerProgress) SetStatus(status string) {
+	p.mu.Lock()
+	changed := p.status != status
+	p.status = status
+	p.mu.Unlock()
+
+	if changed && !p.display.tty {
+		p.display.printLine(p)
+	}
+}
+
+// SetSource records the endpoint serving the layer, shown after its status.
+func (p *layerProgress) SetSource(source string) {
+	p.mu.Lock()
+	p.source = source
+	p.mu.Unlock()
+}
+
+// StartTransfer records the starting offset and size of a (possibly resumed) download.
+func (p *layerProgress) StartTransfer(offset, total int64) {
+	p.mu.Lock()
+	p.current = offset
+	p.resumed = offset
+	p.total = total
+	p.start = time.Now()
+	p.mu.Unlock()
+}
+
+// render formats the layer's progress line.
+// Example: "  a3ed95caeb02: Downloading [=========>          ]  12.3 MB/45.6 MB  2.1 MB/s  ETA 15s"
+// With mirrors: "  a3ed95caeb02: Pull complete (from localhost:5000)"
+func (p *layerProgress) render() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	line := fmt.Sprintf("  %s: %s", p.id, p.status)
+	if p.source != "" {
+		line += " (from " + p.source + ")"
+	}
+	if p.status != statusDownloading && p.status != statusResuming && p.status != statusPushing {
+		return line
+	}
+
+	// Rate is computed from bytes transferred in this session only
+	elapsed := time.Since(p.start).Seconds()
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.current-p.resumed) / elapsed
+	}
+
+	if p.total <= 0 {
+		return fmt.Sprintf("%s  %s  %s/s", line, formatBytes(p.current), formatBytes(int64(rate)))
+	}
+
+	filled := int(float64(progressBarWidth) * float64(p.current) / float64(p.total))
+	filled = min(max(filled, 0), progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+
+	eta := "--"
+	if rate > 0 {
+		remaining := time.Duration(float64(p.total-p.current)/rate) * time.Second
+		eta = remaining.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s [%s]  %s/%s  %s/s  ETA %s", line, bar,
+		formatBytes(p.current), formatBytes(p.total), formatBytes(int64(rate)), eta)
+}
+
+// progressDisplay renders one progress line per layer.
+// On a terminal, lines are redrawn in place; otherwise a line is printed
+// whenever a layer changes status.
+type progressDisplay struct {
+	mu     sync.Mutex
+	out    io.Writer
+	tty    bool
+	layers []*layerProgress
+	drawn  int // Number of lines drawn by the last redraw (TTY only)
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newProgressDisplay creates a display writing to stdout.
+func newProgressDisplay() *progressDisplay {
+	return &progressDisplay{
+		out:  os.Stdout,
+		tty:  isTerminal(os.Stdout),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// AddLayer registers a layer and returns its progress tracker.
+func (d *progressDisplay) AddLayer(digest string) *layerProgress {
+	p := &layerProgress{id: shortDigest(digest), status: statusWaiting, display: d}
+	d.mu.Lock()
+	d.layers = append(d.layers, p)
+	d.mu.Unlock()
+	return p
+}
+
+// Start begins periodic redraws (TTY only). Call Stop when all layers finish.
+func (d *progressDisplay) Start() {
+	if !d.tty {
+		close(d.done)
+		return
+	}
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.redraw()
+			case <-d.stop:
+				d.redraw()
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts redrawing after a final frame.
+func (d *progressDisplay) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+// redraw moves the cursor back over the previous frame and repaints every line.
+func (d *progressDisplay) redraw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+	if d.drawn > 0 {
+		fmt.Fprintf(&b, "\033[%dA", d.drawn) // Cursor up
+	}
+	for _, p := range d.layers {
+		b.WriteString("\033[2K") // Clear line
+		b.WriteString(p.render())
+		b.WriteString("\n")
+	}
+	d.drawn = len(d.layers)
+	io.WriteString(d.out, b.String())
+}
+
+// printLine prints a single status line (non-TTY mode).
+func (d *progressDisplay) printLine(p *layerProgress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintln(d.out, p.render())
+}
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// formatBytes converts bytes to a short human-readable size (e.g., "3.2 MB").
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	suffixes := []string{"KB", "MB", "GB", "TB"}
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
@@ -0,0 +1,370 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pruneGracePeriod is how long unreferenced layers and temporary files are
+// left alone: a pull, commit or build in progress extracts its layers before
+// the image that references them is saved.
+const pruneGracePeriod = time.Hour
+
+// PruneOptions selects what `image prune` removes.
+type PruneOptions struct {
+	All        bool             // Remove all images not used by a container, not just untagged ones
+	Until      time.Time        // Only remove images created before this time (zero: no limit)
+	Containers []ContainerImage // All containers; their images and layers are never removed
+}
+
+// PruneReport lists what Prune removed.
+type PruneReport struct {
+	Untagged       []string // "name:tag" references removed
+	DeletedImages  []string // IDs of deleted images
+	DeletedLayers  []string // DiffIDs of deleted snapshots (digests for layers of the old layer store)
+	DeletedBlobs   []string // Digests of blobs deleted from the content store
+	TempFiles      []string // Paths of removed temporary files and partial downloads
+	ReclaimedBytes int64    // Total size of everything removed
+}
+
+// Prune removes unused images and then garbage-collects the snapshots and
+// the content store.
+//
+// Images are selected first: untagged images, or with opts.All every image,
+// created before opts.Until and not used by any container. Then snapshots and
+// blobs are swept mark-and-sweep style: every snapshot referenced by a
+// remaining image or by a container's overlay, and every manifest, config and
+// layer blob of a remaining image, is marked; everything else is removed,
+// including orphans left behind by interrupted pulls. Staging directories and
+// markers of interrupted extractions and what is left of the old layer store
+// are cleaned up, and finally stale temporary files and partial downloads are
+// deleted. Snapshots and blobs whose lock is held by a pull or removal in
+// progress are skipped.
+//
+// Unreferenced snapshots, blobs and temporary files younger than
+// pruneGracePeriod are kept, since they may belong to an operation still in
+// progress; those of the images deleted here are removed regardless of age.
+// A snapshot's age is that of its completion marker (its directory takes the
+// mtime recorded in the layer), and pulls refresh the markers and blobs they
+// reuse (see touchSnapshot and touchContent).
+//
+// Parameters:
+//   - opts: which images to remove and which layers containers use
+//
+// Returns:
+//   - *PruneReport: what was removed and the space reclaimed
+//   - error: if the image store cannot be read or changed
+func Prune(opts PruneOptions) (*PruneReport, error) {
+	report := &PruneReport{}
+	released := make(map[string]bool) // Snapshots and blobs of the deleted images (digest hex)
+
+	// Step 1: Delete the selected images
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, fmt.Errorf("list images: %w", err)
+	}
+	for _, id := range ids {
+		meta, err := loadMetadataByID(id)
+		if err != nil {
+			continue // Unreadable metadata: leave it for the user to inspect
+		}
+		tags := repos.tagsOf(id)
+		if len(tags) > 0 && !opts.All {
+			continue
+		}
+		if !opts.Until.IsZero() && !meta.CreatedAt.Before(opts.Until) {
+			continue
+		}
+		if slices.ContainsFunc(opts.Containers, func(c ContainerImage) bool { return c.uses(meta) }) {
+			continue
+		}
+
+		if len(tags) > 0 {
+			err := updateRepositories(func(repos *repositories) {
+				for _, tag := range tags {
+					if repos.Tags[tag] == id { // Unless it was moved meanwhile
+						delete(repos.Tags, tag)
+					}
+				}
+			})
+			if err != nil {
+				return report, err
+			}
+			report.Untagged = append(report.Untagged, tags...)
+		}
+
+		if err := os.RemoveAll(ImageDir(id)); err != nil {
+			return report, fmt.Errorf("remove image %s: %w", id[:12], err)
+		}
+		report.DeletedImages = append(report.DeletedImages, id)
+		for _, digest := range meta.references() {
+			released[strings.TrimPrefix(digest, "sha256:")] = true
+		}
+	}
+
+	// Step 2: Mark the snapshots and blobs still in use
+	snapshots, blobs, err := referencedContent()
+	if err != nil {
+		return report, err
+	}
+	markContainerLayers(snapshots, opts.Containers)
+
+	// Step 3: Sweep every other snapshot, then every other blob
+	cutoff := time.Now().Add(-pruneGracePeriod)
+	sweep := func(dir string, marked map[string]bool, tryLock func(string) (func(), bool, error),
+		modTime func(digest string) time.Time, remove func(digest string) error, deleted *[]string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("read %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			// Dot entries (download, staging, lock and marker areas) are not content
+			if strings.HasPrefix(entry.Name(), ".") || marked[entry.Name()] {
+				continue
+			}
+			digest := "sha256:" + entry.Name()
+			fresh := func() bool { return !released[entry.Name()] && modTime(digest).After(cutoff) }
+			info, err := entry.Info()
+			if err != nil || fresh() {
+				continue
+			}
+			size := info.Size()
+			if entry.IsDir() {
+				size, _ = dirSize(filepath.Join(dir, entry.Name()))
+			}
+			// Checked again under the lock: a pull that just stored or reused
+			// the content refreshed it, and its image may not be saved yet
+			skipped := false
+			removed, err := pruneLocked(tryLock, digest, func() error {
+				if skipped = fresh(); skipped {
+					return nil
+				}
+				return remove(digest)
+			})
+			if err != nil {
+				return err
+			}
+			if removed && !skipped {
+				*deleted = append(*deleted, digest)
+				report.ReclaimedBytes += size
+			}
+		}
+		return nil
+	}
+	if err := sweep(SnapshotBaseDir, snapshots, tryLockSnapshot, snapshotModTime, removeSnapshotLocked, &report.DeletedLayers); err != nil {
+		return report, err
+	}
+	if err := sweep(filepath.Join(ContentBaseDir, "sha256"), blobs, tryLockContent, contentModTime, removeContent, &report.DeletedBlobs); err != nil {
+		return report, err
+	}
+
+	// Step 4: Clean up after interrupted extractions: staging directories
+	// and markers of snapshots that no longer exist
+	if err := pruneStaging(report); err != nil {
+		return report, err
+	}
+
+	// Step 5: Remove what is left of the old layer store
+	if err := pruneLegacyLayers(report, opts.Containers); err != nil {
+		return report, err
+	}
+
+	// Step 6: Remove stale temporary files
+	if err := pruneTempFiles(report, cutoff); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// pruneLocked runs remove if the lock of digest taken with tryLock is free,
+// reporting whether it ran. Busy snapshots and blobs belong to an operation
+// in progress and are left alone.
+func pruneLocked(tryLock func(string) (func(), bool, error), digest string, remove func() error) (bool, error) {
+	unlock, ok, err := tryLock(digest)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer unlock()
+	return true, remove()
+}
+
+// pruneStaging removes staging directories of extractions that did not
+// finish and completion markers whose snapshot directory is gone.
+func pruneStaging(report *PruneReport) error {
+	staged, err := os.ReadDir(SnapshotStagingDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read staging dir: %w", err)
+	}
+	for _, entry := range staged {
+		// Staging directories are named "<blob digest hex>-<random>" and
+		// extracted under the blob's lock
+		hex, _, _ := strings.Cut(entry.Name(), "-")
+		path := filepath.Join(SnapshotStagingDir, entry.Name())
+		size, _ := dirSize(path)
+		removed, err := pruneLocked(tryLockContent, "sha256:"+hex, func() error { return os.RemoveAll(path) })
+		if err != nil {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+		if removed {
+			report.TempFiles = append(report.TempFiles, path)
+			report.ReclaimedBytes += size
+		}
+	}
+
+	markers, err := os.ReadDir(snapshotMarkerDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read marker dir: %w", err)
+	}
+	for _, entry := range markers {
+		// Leftover "<hex>.tmp" files are markers whose write was interrupted
+		hex, partial := strings.CutSuffix(entry.Name(), ".tmp")
+		path := filepath.Join(snapshotMarkerDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(SnapshotBaseDir, hex)); !partial && !os.IsNotExist(err) {
+			continue
+		}
+		if _, err := pruneLocked(tryLockSnapshot, "sha256:"+hex, func() error { return os.Remove(path) }); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove layer marker: %w", err)
+		}
+	}
+	return nil
+}
+
+// pruneLegacyLayers cleans up the layer store of older versions once it has
+// been migrated: layers that were not moved to snapshots (unreferenced or
+// incomplete) are deleted, and so are the symlinks from old layer paths that
+// no container uses any more. The directory goes once nothing is left in it.
+func pruneLegacyLayers(report *PruneReport, containers []ContainerImage) error {
+	if _, err := os.Stat(legacyMigratedPath); err != nil {
+		return nil // Not there, or not migrated yet
+	}
+	used := make(map[string]bool)
+	for _, c := range containers {
+		for _, dir := range c.LayerDirs {
+			used[filepath.Clean(dir)] = true
+		}
+	}
+
+	entries, err := os.ReadDir(LayerBaseDir)
+	if err != nil {
+		return fmt.Errorf("read layer store: %w", err)
+	}
+	remaining := 0
+	for _, entry := range entries {
+		path := filepath.Join(LayerBaseDir, entry.Name())
+		switch {
+		case strings.HasPrefix(entry.Name(), "."):
+			continue // Migration marker and lock, old download and lock areas
+		case entry.Type()&os.ModeSymlink != 0:
+			if used[path] {
+				remaining++
+				continue
+			}
+		default:
+			size, _ := dirSize(path)
+			report.DeletedLayers = append(report.DeletedLayers, "sha256:"+entry.Name())
+			report.ReclaimedBytes += size
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+	}
+	if remaining == 0 {
+		return os.RemoveAll(LayerBaseDir)
+	}
+	return nil
+}
+
+// pruneTempFiles removes temporary files not modified since cutoff: partial
+// downloads and push, commit and save archives in DownloadDir, and
+// "layer-*.tar.gz" files older versions left in the system temp directory.
+// Partial downloads are named after their blob digest and removed under the
+// blob's lock; those a pull is resuming are skipped.
+func pruneTempFiles(report *PruneReport, cutoff time.Time) error {
+	var paths []string
+	entries, err := os.ReadDir(DownloadDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read download dir: %w", err)
+	}
+	for _, entry := range entries {
+		paths = append(paths, filepath.Join(DownloadDir, entry.Name()))
+	}
+	legacy, err := filepath.Glob(filepath.Join(os.TempDir(), "layer-*.tar.gz"))
+	if err != nil {
+		return err
+	}
+	paths = append(paths, legacy...)
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		removed, skipped := true, false
+		if digest := "sha256:" + filepath.Base(path); filepath.Dir(path) == DownloadDir && ValidateDigest(digest) == nil {
+			// Checked again under the lock: a resumed download appends to the file
+			removed, err = pruneLocked(tryLockContent, digest, func() error {
+				if info, err := os.Lstat(path); err != nil || info.ModTime().After(cutoff) {
+					skipped = true
+					return nil
+				}
+				return os.Remove(path)
+			})
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+		if !removed || skipped {
+			continue
+		}
+		report.TempFiles = append(report.TempFiles, path)
+		report.ReclaimedBytes += info.Size()
+	}
+	return nil
+}
+
+// referencedContent returns the snapshots (diffID hex) and blobs (digest hex)
+// used by any stored image, tagged or not.
+func referencedContent() (snapshots, blobs map[string]bool, err error) {
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("list images: %w", err)
+	}
+
+	snapshots = make(map[string]bool)
+	blobs = make(map[string]bool)
+	for _, id := range ids {
+		meta, err := loadMetadataByID(id)
+		if err != nil {
+			return nil, nil, fmt.Errorf("image %s: %w", id, err)
+		}
+		for _, diffID := range meta.DiffIDs {
+			snapshots[strings.TrimPrefix(diffID, "sha256:")] = true
+		}
+		for _, digest := range meta.references() {
+			blobs[strings.TrimPrefix(digest, "sha256:")] = true
+		}
+	}
+	return snapshots, blobs, nil
+}
+
+// markContainerLayers adds the snapshots (diffID hex) that containers run on
+// to marked. Directories outside the image store (--rootfs) are ignored.
+func markContainerLayers(marked map[string]bool, containers []ContainerImage) {
+	for _, c := range containers {
+		for _, dir := range c.LayerDirs {
+			if dir = resolveLayerDir(dir); filepath.Dir(dir) == SnapshotBaseDir {
+				marked[filepath.Base(dir)] = true
+			}
+		}
+	}
+}
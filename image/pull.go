@@ -4,14 +4,34 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
+// DefaultMaxConcurrentDownloads is the number of layers downloaded at once
+// when PullOptions.MaxConcurrentDownloads is not set.
+const DefaultMaxConcurrentDownloads = 3
+
+// PullOptions configures how an image is pulled.
+type PullOptions struct {
+	MaxConcurrentDownloads int      // Max layers downloaded in parallel (default: 3)
+	Platform               Platform // Platform to select from multi-arch images (default: host)
+}
+
 // Pull downloads an image from a registry and stores it locally.
+// Every blob is verified against the digest listed in the manifest, and each
+// extracted layer is checked against the config's rootfs.diff_ids. Images in
+// a scope of the trust policy must be signed by one of its keys (see verifyPull).
+// The manifest and config are added to the content store as served, and so
+// are the layer blobs unless the storage config says not to keep them.
 // Returns the image metadata on success.
-func Pull(refStr string) (*ImageMetadata, error) {
+func Pull(refStr string, opts PullOptions) (*ImageMetadata, error) {
 	// Step 1: Parse reference
-	ref := ParseReference(refStr)
+	ref, err := ParseReference(refStr)
+	if err != nil {
+		return nil, err
+	}
 	fmt.Printf("Pulling %s...\n", ref.String())
 
 	// Step 2: Ensure directories exist
@@ -20,101 +40,312 @@ func Pull(refStr string) (*ImageMetadata, error) {
 	}
 
 	// Step 3: Create registry client and authenticate
-	client := NewRegistryClient(ref)
-	fmt.Printf("  Authenticating with %s...\n", ref.Registry)
-	if err := client.Authenticate(); err != nil {
-		return nil, fmt.Errorf("authenticate: %w", err)
+	// With mirrors, each endpoint authenticates when it is first used
+	client, err := NewRegistryClient(ref)
+	if err != nil {
+		return nil, err
+	}
+	mirrors, err := client.UseMirrors()
+	if err != nil {
+		return nil, err
+	}
+	if len(mirrors) > 0 {
+		fmt.Printf("  Using mirrors: %s (then %s)\n", strings.Join(mirrors, ", "), ref.Registry)
+	} else {
+		fmt.Printf("  Authenticating with %s...\n", ref.Registry)
+		if err := client.Authenticate(); err != nil {
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
 	}
 
-	// Step 4: Fetch manifest
-	fmt.Printf("  Fetching manifest...\n")
-	manifest, err := client.FetchManifest()
+	// Step 4: Fetch manifest for the requested platform
+	platform := opts.Platform
+	if platform.OS == "" {
+		platform = HostPlatform()
+	}
+	fmt.Printf("  Fetching manifest (%s)...\n", platform)
+	manifest, err := client.FetchManifest(platform)
 	if err != nil {
 		return nil, fmt.Errorf("fetch manifest: %w", err)
 	}
+	resolved, repoDigest := manifest.Platform, manifest.RepoDigest
+	if source := client.ServedBy(repoDigest); source != "" {
+		fmt.Printf("  Manifest from %s\n", source)
+	}
+
+	// Step 5: Check its signature before anything else is downloaded
+	signatures, err := verifyPull(client, ref, manifest)
+	if err != nil {
+		return nil, err
+	}
 
-	// Step 5: Download and extract layers
-	var layerDigests []string
-	var totalSize int64
+	// Step 6: Fetch image config (Entrypoint, Cmd, Env, WorkingDir, User, diff_ids)
+	// Fetched before the layers so each layer's diffID can be checked as it is extracted
+	fmt.Printf("  Fetching config...\n")
+	config, configBlob, err := client.FetchConfig(manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("fetch config: %w", err)
+	}
+	if source := client.ServedBy(manifest.Config.Digest); source != "" {
+		fmt.Printf("  Config from %s\n", source)
+	}
+	// Single-platform images only declare their platform in the config
+	if resolved.OS == "" {
+		resolved = Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+		if !platform.Matches(resolved) {
+			fmt.Printf("  Warning: image platform (%s) does not match requested platform (%s)\n", resolved, platform)
+		}
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("config lists %d diff_ids but manifest has %d layers",
+			len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	if err := validateDiffIDs(config.RootFS.DiffIDs); err != nil {
+		return nil, err
+	}
 
+	// Step 7: Download and extract layers concurrently
+	keep, err := keepLayerBlobs()
+	if err != nil {
+		return nil, err
+	}
+	layerDigests, totalSize, err := pullLayers(client, manifest.ManifestV2, config, opts.MaxConcurrentDownloads, keep)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writeContent(manifest.Raw); err != nil {
+		return nil, err
+	}
+	if _, err := writeContent(configBlob); err != nil {
+		return nil, err
+	}
+
+	// Step 8: Create and save metadata.
+	// Stored under the normalized short name ("alpine" for Docker Hub's
+	// library/alpine); a digest-only reference records the repo digest but
+	// creates no tag.
+	name := ref.Name()
+	meta := &ImageMetadata{
+		ID:             manifest.Config.Digest[7:], // Strip "sha256:" prefix
+		Layers:         layerDigests,
+		DiffIDs:        config.RootFS.DiffIDs,
+		ConfigDigest:   manifest.Config.Digest,
+		ManifestDigest: manifest.Digest,
+		Platform:       resolved.String(),
+		CreatedAt:      time.Now(),
+		Size:           totalSize,
+	}
+	if ref.Tag != "" {
+		meta.Name, meta.Tag = name, ref.Tag
+	}
+	meta.AddRepoDigest(name, repoDigest)
+	for _, sig := range signatures {
+		meta.AddSignature(sig)
+	}
+
+	// The config goes first: saving the metadata creates the tag
+	if err := SaveConfig(meta.ID, config); err != nil {
+		return nil, fmt.Errorf("save config: %w", err)
+	}
+	if err := SaveMetadata(meta); err != nil {
+		return nil, fmt.Errorf("save metadata: %w", err)
+	}
+
+	fmt.Printf("  Digest: %s\n", repoDigest)
+	fmt.Printf("  Done! Image %s pulled.\n", refStr)
+	return meta, nil
+}
+
+// pullLayers downloads, verifies and extracts all layers of a manifest,
+// running up to maxConcurrent downloads at a time with live progress output.
+// Layers are independent directories, so they can be extracted in any order.
+// With keep set, the layer blobs are kept in the content store.
+//
+// Returns:
+//   - layer digests in manifest order (bottom to top)
+//   - total bytes downloaded
+//   - the first error encountered, after all in-flight downloads finish
+func pullLayers(client *RegistryClient, manifest *ManifestV2, config *ImageConfig, maxConcurrent int, keep bool) ([]string, int64, error) {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrentDownloads
+	}
+
+	display := newProgressDisplay()
+	progress := make([]*layerProgress, len(manifest.Layers))
 	for i, layer := range manifest.Layers {
-		fmt.Printf("  Downloading layer %d/%d (%s)...\n", i+1, len(manifest.Layers), layer.Digest[:19])
+		progress[i] = display.AddLayer(layer.Digest)
+	}
+	display.Start()
 
-		// Check if layer already exists (caching)
-		if LayerExists(layer.Digest) {
-			fmt.Printf("    Layer already exists, skipping.\n")
-			layerDigests = append(layerDigests, layer.Digest)
-			continue
-		}
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		firstErr  error
+		totalSize int64
+		sem       = make(chan struct{}, maxConcurrent)
+		digests   = make([]string, len(manifest.Layers))
+	)
 
-		// Download layer to temp file
-		layerPath, size, err := downloadLayer(client, layer.Digest)
-		if err != nil {
-			return nil, fmt.Errorf("download layer: %w", err)
-		}
-		totalSize += size
+	for i, layer := range manifest.Layers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
-		// Extract layer
-		digest, _, err := ExtractLayer(layerPath)
+			size, err := pullLayer(client, layer, config.RootFS.DiffIDs[i], keep, progress[i])
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				progress[i].SetStatus(statusFailed)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			digests[i] = layer.Digest
+			totalSize += size
+		}()
+	}
+
+	wg.Wait()
+	display.Stop()
+
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	return digests, totalSize, nil
+}
+
+// pullLayer downloads and extracts a single layer unless it is already stored.
+// The partial download is kept on network errors so the next pull can resume it.
+// The blob's lock is held from download to extraction, so a concurrent pull
+// of an image sharing the layer waits and then finds it stored.
+// With keep set, the blob is moved into the content store (and downloaded
+// even if the snapshot exists, so the image can be pushed and saved as is);
+// otherwise it is deleted once extracted.
+// Returns the number of bytes downloaded.
+func pullLayer(client *RegistryClient, layer Descriptor, diffID string, keep bool, progress *layerProgress) (int64, error) {
+	digest := layer.Digest
+	compression, err := layerCompression(layer.MediaType)
+	if err != nil {
+		return 0, err
+	}
+	unlock, err := lockContent(digest)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// Check if layer already exists (caching); reusing it refreshes it for prune
+	if !keep || (HasContent(digest) && touchContent(digest) == nil) {
+		reused, err := touchSnapshot(diffID)
 		if err != nil {
-			os.Remove(layerPath)
-			return nil, fmt.Errorf("extract layer: %w", err)
+			return 0, err
+		}
+		if reused {
+			progress.SetStatus(statusExists)
+			return 0, nil
 		}
-		os.Remove(layerPath) // Clean up temp file
+	}
 
-		layerDigests = append(layerDigests, digest)
+	// Download layer into the partial-download area, verifying its digest
+	blobPath := ContentPath(digest)
+	var size int64
+	if !HasContent(digest) {
+		if blobPath, size, err = downloadLayer(client, layer, progress); err != nil {
+			return 0, fmt.Errorf("download layer %s: %w", shortDigest(digest), err)
+		}
+		if keep {
+			if err := ingestContent(blobPath, digest, true); err != nil {
+				return 0, err
+			}
+			blobPath = ContentPath(digest)
+		} else {
+			defer os.Remove(blobPath) // The verified blob is no longer needed afterwards
+		}
 	}
 
-	// Step 6: Create and save metadata
-	meta := &ImageMetadata{
-		ID:           manifest.Config.Digest[7:], // Strip "sha256:" prefix
-		Name:         ref.Repository,
-		Tag:          ref.Tag,
-		Layers:       layerDigests,
-		ConfigDigest: manifest.Config.Digest,
-		CreatedAt:    time.Now(),
-		Size:         totalSize,
+	// Extract layer, verifying the uncompressed content matches the config
+	progress.SetStatus(statusExtracting)
+	if _, _, err := unpackLayerLocked(blobPath, digest, diffID, compression); err != nil {
+		return 0, fmt.Errorf("extract layer %s: %w", shortDigest(digest), err)
 	}
 
-	// Use simple name for storage (without registry prefix)
-	name, _ := ParseImageRef(refStr)
-	meta.Name = name
+	progress.SetStatus(statusComplete)
+	return size, nil
+}
 
-	if err := SaveMetadata(meta); err != nil {
-		return nil, fmt.Errorf("save metadata: %w", err)
+// downloadLayer downloads a layer blob to its partial-download file.
+// If a partial file exists from an interrupted pull, the download resumes
+// from its end with an HTTP Range request. The blob is hashed as it streams
+// (including resumed bytes) and rejected if it does not match digest.
+// Foreign layers the registry does not serve come from their descriptor URLs.
+// Returns the path to the completed blob and the bytes transferred this time.
+func downloadLayer(client *RegistryClient, layer Descriptor, progress *layerProgress) (string, int64, error) {
+	digest := layer.Digest
+	verifier, err := newDigestVerifier(digest)
+	if err != nil {
+		return "", 0, err
 	}
 
-	fmt.Printf("  Done! Image %s:%s pulled.\n", meta.Name, meta.Tag)
-	return meta, nil
-}
+	// Open (or create) the partial download
+	blobPath := PartialBlobPath(digest)
+	file, err := os.OpenFile(blobPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return "", 0, fmt.Errorf("open partial download: %w", err)
+	}
+	defer file.Close()
 
-// downloadLayer downloads a layer blob to a temp file.
-// Returns the path to the temp file and its size.
-func downloadLayer(client *RegistryClient, digest string) (string, int64, error) {
-	body, size, err := client.FetchBlob(digest)
+	// Hash what we already have; the file offset ends up at its end
+	offset, err := io.Copy(verifier, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("read partial download: %w", err)
+	}
+
+	body, resumed, total, err := client.FetchLayerRange(layer, offset)
 	if err != nil {
 		return "", 0, err
 	}
 	defer body.Close()
+	progress.SetSource(client.ServedBy(digest))
 
-	// Create temp file for layer tarball
-	tmpFile, err := os.CreateTemp("", "layer-*.tar.gz")
-	if err != nil {
-		return "", 0, fmt.Errorf("create temp file: %w", err)
+	// Server ignored the range: start over from an empty file
+	if offset > 0 && !resumed {
+		if err := file.Truncate(0); err != nil {
+			return "", 0, fmt.Errorf("truncate partial download: %w", err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", 0, fmt.Errorf("rewind partial download: %w", err)
+		}
+		verifier, _ = newDigestVerifier(digest)
+		offset = 0
 	}
-	defer tmpFile.Close()
 
-	// Copy blob to temp file
-	written, err := io.Copy(tmpFile, body)
+	progress.StartTransfer(offset, total)
+	if offset > 0 {
+		progress.SetStatus(statusResuming)
+	} else {
+		progress.SetStatus(statusDownloading)
+	}
+
+	// Append blob to the partial file, hashing and counting as we go
+	written, err := io.Copy(io.MultiWriter(file, verifier, progress), body)
 	if err != nil {
-		os.Remove(tmpFile.Name())
-		return "", 0, fmt.Errorf("download layer: %w", err)
+		// Keep the partial file: the next pull resumes from here
+		return "", 0, fmt.Errorf("download interrupted after %d bytes: %w", offset+written, err)
 	}
 
-	if size > 0 && written != size {
-		os.Remove(tmpFile.Name())
-		return "", 0, fmt.Errorf("size mismatch: expected %d, got %d", size, written)
+	if total > 0 && offset+written != total {
+		return "", 0, fmt.Errorf("size mismatch: expected %d, got %d", total, offset+written)
+	}
+
+	progress.SetStatus(statusVerifying)
+	if err := verifier.Verify(); err != nil {
+		// Corrupt data cannot be resumed; discard it
+		os.Remove(blobPath)
+		return "", 0, err
 	}
 
-	return tmpFile.Name(), written, nil
+	return blobPath, written, nil
 }
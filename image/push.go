@@ -0,0 +1,343 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Push uploads a local image to a registry.
+// An image whose original manifest, config and layer blobs are all in the
+// content store is pushed exactly as it was pulled or loaded, so it keeps its
+// manifest digest. Otherwise a Docker v2 manifest is generated: stored gzip
+// and plain tar layer blobs are reused, other layers are re-archived from
+// their snapshots and gzip-compressed, and the stored config is reused when
+// the layers are unchanged. Blobs the registry already has are skipped, and
+// the manifest is pushed last, so the tag only appears once all of its blobs
+// are in place.
+//
+// Parameters:
+//   - refStr: image reference, e.g. "localhost:5000/myapp:v1" or "myuser/myapp"
+//
+// Returns:
+//   - string: digest of the pushed manifest
+//   - error: any error during push
+func Push(refStr string) (string, error) {
+	// Step 1: Parse reference and load the local image
+	ref, err := ParseReference(refStr)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return "", fmt.Errorf("cannot push a digest reference, push %s by tag", refStr)
+	}
+	name := ref.Name()
+	meta, err := LoadMetadata(name, ref.Tag)
+	if err != nil {
+		return "", err
+	}
+	if len(meta.DiffIDs) != len(meta.Layers) {
+		return "", fmt.Errorf("image %s has no layer diff_ids, pull or import it again", refStr)
+	}
+	if err := EnsureImageDirs(); err != nil {
+		return "", fmt.Errorf("ensure image dirs: %w", err)
+	}
+	fmt.Printf("Pushing %s...\n", ref.String())
+
+	// Step 2: Create registry client and authenticate with push access
+	client, err := NewRegistryClient(ref)
+	if err != nil {
+		return "", err
+	}
+	client.actions = "push,pull"
+	fmt.Printf("  Authenticating with %s...\n", ref.Registry)
+	if err := client.Authenticate(); err != nil {
+		return "", fmt.Errorf("authenticate: %w", err)
+	}
+
+	// Step 3: Upload the layers and config of the stored manifest, or of a generated one
+	manifest, manifestType, manifestBlob, err := storedManifest(meta)
+	if err != nil {
+		return "", err
+	}
+	if manifest != nil {
+		err = pushStoredImage(client, manifest)
+	} else {
+		manifestType, manifestBlob, err = pushGeneratedImage(client, meta)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Step 4: Upload the manifest under the tag
+	digest, err := client.PutManifest(ref.Tag, manifestType, manifestBlob)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("  %s: digest: %s size: %d\n", ref.Tag, digest, len(manifestBlob))
+
+	// Step 5: Remember the repo digest, so the image can be referenced by it
+	meta.AddRepoDigest(name, digest)
+	if err := SaveMetadata(meta); err != nil {
+		return "", fmt.Errorf("save metadata: %w", err)
+	}
+	return digest, nil
+}
+
+// storedManifest returns the original manifest of an image, with its media
+// type and raw bytes, if it and every blob it references are in the content
+// store. Non-distributable layers need not be: they are never pushed or saved.
+// Returns a nil manifest if the image must be rebuilt from its snapshots.
+func storedManifest(meta *ImageMetadata) (*ManifestV2, string, []byte, error) {
+	if meta.ManifestDigest == "" || !HasContent(meta.ManifestDigest) || !HasContent(meta.ConfigDigest) {
+		return nil, "", nil, nil
+	}
+	blob, err := readContent(meta.ManifestDigest)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var manifest ManifestV2
+	if err := json.Unmarshal(blob, &manifest); err != nil {
+		return nil, "", nil, fmt.Errorf("parse stored manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		if lt, err := parseLayerMediaType(layer.MediaType); err == nil && !lt.foreign && !HasContent(layer.Digest) {
+			return nil, "", nil, nil
+		}
+	}
+
+	// OCI manifests may omit their media type
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeOCIManifest
+	}
+	return &manifest, mediaType, blob, nil
+}
+
+// pushStoredImage uploads the layer and config blobs of a stored manifest
+// from the content store. Non-distributable layers are left out: clients
+// download them from the URLs in their descriptors.
+func pushStoredImage(client *RegistryClient, manifest *ManifestV2) error {
+	display := newProgressDisplay()
+	progress := make([]*layerProgress, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		progress[i] = display.AddLayer(layer.Digest)
+	}
+	display.Start()
+	defer display.Stop()
+
+	for i, layer := range manifest.Layers {
+		if lt, _ := parseLayerMediaType(layer.MediaType); lt.foreign {
+			progress[i].SetStatus(statusForeign)
+			continue
+		}
+		if err := pushContent(client, layer.Digest, progress[i]); err != nil {
+			progress[i].SetStatus(statusFailed)
+			return fmt.Errorf("push layer %s: %w", shortDigest(layer.Digest), err)
+		}
+	}
+
+	fmt.Printf("  Pushing config...\n")
+	configBlob, err := readContent(manifest.Config.Digest)
+	if err != nil {
+		return err
+	}
+	if err := pushBlob(client, manifest.Config, configBlob); err != nil {
+		return fmt.Errorf("push config: %w", err)
+	}
+	return nil
+}
+
+// pushGeneratedImage uploads the layers and config of an image without a
+// complete stored manifest and builds a Docker v2 manifest for them.
+// Returns the manifest's media type and bytes.
+func pushGeneratedImage(client *RegistryClient, meta *ImageMetadata) (string, []byte, error) {
+	// Step 1: Upload layers
+	layers, diffIDs, err := pushLayers(client, meta)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Step 2: Upload the config; the stored one still matches unless layers were re-archived
+	var configBlob []byte
+	if slices.Equal(diffIDs, meta.DiffIDs) && meta.ConfigDigest != "" && HasContent(meta.ConfigDigest) {
+		configBlob, err = readContent(meta.ConfigDigest)
+	} else {
+		configBlob, err = imageConfigBlob(meta, diffIDs)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	configDesc := Descriptor{
+		MediaType: MediaTypeDockerConfig,
+		Digest:    digestBytes(configBlob),
+		Size:      int64(len(configBlob)),
+	}
+	fmt.Printf("  Pushing config...\n")
+	if err := pushBlob(client, configDesc, configBlob); err != nil {
+		return "", nil, fmt.Errorf("push config: %w", err)
+	}
+
+	// Step 3: Generate the manifest
+	manifestBlob, err := json.Marshal(ManifestV2{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeDockerManifest,
+		Config:        configDesc,
+		Layers:        layers,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("marshal manifest: %w", err)
+	}
+	return MediaTypeDockerManifest, manifestBlob, nil
+}
+
+// pushLayers uploads each layer, bottom to top: its stored blob if it is one
+// a Docker manifest can reference (gzip or plain tar), otherwise its snapshot
+// re-archived.
+//
+// Returns:
+//   - the manifest descriptors of the pushed layers
+//   - the diff_ids of the pushed layers
+//   - the first error encountered
+func pushLayers(client *RegistryClient, meta *ImageMetadata) ([]Descriptor, []string, error) {
+	display := newProgressDisplay()
+	progress := make([]*layerProgress, len(meta.Layers))
+	for i, digest := range meta.Layers {
+		progress[i] = display.AddLayer(digest)
+	}
+	display.Start()
+	defer display.Stop()
+
+	layers := make([]Descriptor, len(meta.Layers))
+	diffIDs := make([]string, len(meta.Layers))
+	for i, digest := range meta.Layers {
+		desc, diffID, err := pushStoredLayer(client, digest, meta.DiffIDs[i], progress[i])
+		if err == nil && desc.Digest == "" {
+			desc, diffID, err = pushLayer(client, SnapshotDir(meta.DiffIDs[i]), progress[i])
+		}
+		if err != nil {
+			progress[i].SetStatus(statusFailed)
+			return nil, nil, fmt.Errorf("push layer %s: %w", shortDigest(digest), err)
+		}
+		layers[i] = desc
+		diffIDs[i] = diffID
+	}
+
+	return layers, diffIDs, nil
+}
+
+// pushStoredLayer uploads a layer blob from the content store if it is
+// stored and gzip-compressed or a plain tar.
+// Returns the layer's descriptor and diffID, or an empty descriptor if the
+// layer has to be re-archived.
+func pushStoredLayer(client *RegistryClient, digest, diffID string, progress *layerProgress) (Descriptor, string, error) {
+	if !HasContent(digest) {
+		return Descriptor{}, "", nil
+	}
+	compression, err := contentCompression(digest)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	mediaType := map[string]string{
+		compressionGzip: MediaTypeDockerLayerGzip,
+		compressionNone: MediaTypeDockerLayer,
+	}[compression]
+	if mediaType == "" {
+		return Descriptor{}, "", nil // zstd has no Docker media type
+	}
+
+	size, err := contentSize(digest)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	if err := pushContent(client, digest, progress); err != nil {
+		return Descriptor{}, "", err
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: size}, diffID, nil
+}
+
+// pushContent uploads a blob from the content store unless the registry
+// already has it.
+func pushContent(client *RegistryClient, digest string, progress *layerProgress) error {
+	progress.SetStatus(statusPreparing)
+	exists, err := client.BlobExists(digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		progress.SetStatus(statusLayerExists)
+		return nil
+	}
+
+	file, err := os.Open(ContentPath(digest))
+	if err != nil {
+		return fmt.Errorf("open blob %s: %w", shortDigest(digest), err)
+	}
+	defer file.Close()
+	size, err := contentSize(digest)
+	if err != nil {
+		return err
+	}
+
+	progress.StartTransfer(0, size)
+	progress.SetStatus(statusPushing)
+	if err := client.UploadBlob(digest, size, file, progress); err != nil {
+		return err
+	}
+	progress.SetStatus(statusPushed)
+	return nil
+}
+
+// pushLayer compresses a layer directory into a temporary blob and uploads it
+// unless the registry already has a blob with the same digest.
+// Returns the layer's manifest descriptor and diffID.
+func pushLayer(client *RegistryClient, layerDir string, progress *layerProgress) (Descriptor, string, error) {
+	// Compress into the download area, which lives on the same disk as the layers
+	progress.SetStatus(statusPreparing)
+	file, err := os.CreateTemp(DownloadDir, "push-*")
+	if err != nil {
+		return Descriptor{}, "", fmt.Errorf("create temp blob: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	digest, diffID, size, err := compressLayer(layerDir, file)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	desc := Descriptor{MediaType: MediaTypeDockerLayerGzip, Digest: digest, Size: size}
+
+	exists, err := client.BlobExists(digest)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	if exists {
+		progress.SetStatus(statusLayerExists)
+		return desc, diffID, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return Descriptor{}, "", fmt.Errorf("rewind temp blob: %w", err)
+	}
+	progress.StartTransfer(0, size)
+	progress.SetStatus(statusPushing)
+	if err := client.UploadBlob(digest, size, file, progress); err != nil {
+		return Descriptor{}, "", err
+	}
+
+	progress.SetStatus(statusPushed)
+	return desc, diffID, nil
+}
+
+// pushBlob uploads an in-memory blob unless the registry already has it.
+func pushBlob(client *RegistryClient, desc Descriptor, data []byte) error {
+	exists, err := client.BlobExists(desc.Digest)
+	if err != nil || exists {
+		return err
+	}
+	return client.UploadBlob(desc.Digest, desc.Size, bytes.NewReader(data), nil)
+}
@@ -1,77 +1,191 @@
 package image
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
-// ImageReference represents a fully qualified image reference.
+// ImageReference represents a parsed and normalized image reference.
+// Every image command parses its references with ParseReference, so the same
+// image always maps to the same local name however it is spelled.
 // Example: docker.io/library/alpine:3.19
 type ImageReference struct {
 	Registry   string // e.g., "registry-1.docker.io"
 	Repository string // e.g., "library/alpine"
-	Tag        string // e.g., "3.19" (default: "latest")
+	Tag        string // e.g., "3.19" (default: "latest", empty for digest-only references)
+	Digest     string // Manifest digest pinned with "@sha256:..." (optional)
 }
 
 // DefaultRegistry is the Docker Hub registry endpoint.
 const DefaultRegistry = "registry-1.docker.io"
 
-// ParseReference parses an image reference string into its components.
+// maxNameLength is the longest repository name (registry included) accepted,
+// as in the distribution reference grammar.
+const maxNameLength = 255
+
+var (
+	// pathComponentRegexp matches one repository path component:
+	// lowercase alphanumerics separated by ".", "_", "__" or dashes.
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+
+	// registryRegexp matches a registry host name with an optional port.
+	registryRegexp = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?$`)
+
+	// tagRegexp matches a tag: up to 128 word characters, dots and dashes.
+	tagRegexp = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+// ParseReference parses and validates an image reference.
 // Handles various formats:
-//   - "alpine"                    -> registry-1.docker.io/library/alpine:latest
-//   - "alpine:3.19"               -> registry-1.docker.io/library/alpine:3.19
-//   - "nginx"                     -> registry-1.docker.io/library/nginx:latest
-//   - "myuser/myapp"              -> registry-1.docker.io/myuser/myapp:latest
-//   - "ghcr.io/owner/repo:v1"     -> ghcr.io/owner/repo:v1
+//   - "alpine"                     -> registry-1.docker.io/library/alpine:latest
+//   - "alpine:3.19"                -> registry-1.docker.io/library/alpine:3.19
+//   - "docker.io/library/alpine"   -> registry-1.docker.io/library/alpine:latest
+//   - "myuser/myapp"               -> registry-1.docker.io/myuser/myapp:latest
+//   - "ghcr.io/owner/team/repo:v1" -> ghcr.io/owner/team/repo:v1
+//   - "localhost:5000/app"         -> localhost:5000/app:latest
+//   - "alpine@sha256:<hex>"        -> registry-1.docker.io/library/alpine@sha256:<hex>
+//   - "alpine:3.19@sha256:<hex>"   -> registry-1.docker.io/library/alpine:3.19@sha256:<hex>
 //
 // Docker Hub special cases:
 //   - Official images (no /) get "library/" prefix
-//   - Docker Hub registry is "registry-1.docker.io"
-func ParseReference(ref string) ImageReference {
+//   - "docker.io" and "index.docker.io" are normalized to "registry-1.docker.io"
+//
+// A digest pins the manifest; the tag then defaults to none instead of "latest".
+//
+// Returns an error if any part of the reference is malformed: uppercase or
+// otherwise invalid repository names, bad tags, or malformed digests.
+func ParseReference(ref string) (ImageReference, error) {
 	result := ImageReference{
 		Registry: DefaultRegistry,
 		Tag:      "latest",
 	}
+	invalid := func(reason string) (ImageReference, error) {
+		return ImageReference{}, fmt.Errorf("invalid reference format %q: %s", ref, reason)
+	}
+	if ref == "" {
+		return invalid("empty reference")
+	}
+
+	// Step 1: Extract digest if present (after "@")
+	name, digest, hasDigest := strings.Cut(ref, "@")
+	if hasDigest {
+		if err := ValidateDigest(digest); err != nil {
+			return invalid(err.Error())
+		}
+		result.Digest = digest
+		result.Tag = ""
+	}
 
-	// Step 1: Extract tag if present (after last ":")
+	// Step 2: Extract tag if present (after last ":")
 	// But be careful: registry may have port like "localhost:5000/image"
-	if idx := strings.LastIndex(ref, ":"); idx != -1 {
-		// Check if ":" is part of tag (no "/" after it) or port (has "/" after)
-		afterColon := ref[idx+1:]
-		if !strings.Contains(afterColon, "/") {
-			result.Tag = afterColon
-			ref = ref[:idx]
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx+1:], "/") {
+		if !tagRegexp.MatchString(name[idx+1:]) {
+			return invalid("invalid tag " + name[idx+1:])
 		}
+		result.Tag = name[idx+1:]
+		name = name[:idx]
 	}
 
-	// Step 2: Determine if ref contains a registry
+	// Step 3: Determine if name starts with a registry
 	// A registry is present if:
 	//   - First component contains "." (e.g., "ghcr.io")
 	//   - First component contains ":" (e.g., "localhost:5000")
 	//   - First component is "localhost"
-	parts := strings.SplitN(ref, "/", 2)
-
-	if len(parts) == 1 {
-		// No "/", so it's just an image name like "alpine"
-		// Docker Hub official image: add "library/" prefix
-		result.Repository = "library/" + parts[0]
-	} else {
-		firstPart := parts[0]
-		hasRegistry := strings.Contains(firstPart, ".") ||
-			strings.Contains(firstPart, ":") ||
-			firstPart == "localhost"
-
-		if hasRegistry {
-			// First part is a registry
-			result.Registry = firstPart
-			result.Repository = parts[1]
-		} else {
-			// No registry, just "user/repo" format for Docker Hub
-			result.Repository = ref
+	repository := name
+	if first, rest, ok := strings.Cut(name, "/"); ok &&
+		(strings.ContainsAny(first, ".:") || first == "localhost") {
+		if !registryRegexp.MatchString(first) {
+			return invalid("invalid registry " + first)
+		}
+		if !isDockerHub(first) {
+			result.Registry = first
 		}
+		repository = rest
+	}
+
+	// Step 4: Validate the repository path
+	if len(name) > maxNameLength {
+		return invalid(fmt.Sprintf("name longer than %d characters", maxNameLength))
+	}
+	for _, component := range strings.Split(repository, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			if strings.ToLower(component) != component {
+				return invalid("repository name must be lowercase")
+			}
+			return invalid("invalid repository name " + repository)
+		}
+	}
+
+	// Official Docker Hub images live under "library/"
+	if result.Registry == DefaultRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
 	}
+	result.Repository = repository
 
-	return result
+	return result, nil
+}
+
+// ParseTagReference parses a reference that names a new or existing tag,
+// such as the target of `tag` or the name given to an imported or built image.
+// Unlike ParseReference it rejects digests, which cannot be assigned locally.
+func ParseTagReference(ref string) (ImageReference, error) {
+	parsed, err := ParseReference(ref)
+	if err != nil {
+		return ImageReference{}, err
+	}
+	if parsed.Digest != "" {
+		return ImageReference{}, fmt.Errorf("invalid tag %s: a tag cannot contain a digest", ref)
+	}
+	return parsed, nil
+}
+
+// Name returns the short name images are stored under locally: the
+// repository without Docker Hub's registry and "library/" prefix, or
+// "registry/repository" for other registries.
+// "alpine", "docker.io/library/alpine" and "registry-1.docker.io/library/alpine"
+// all have the name "alpine".
+func (r ImageReference) Name() string {
+	if r.Registry == DefaultRegistry {
+		return strings.TrimPrefix(r.Repository, "library/")
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// TagKey returns the local "name:tag" key of a tagged reference.
+func (r ImageReference) TagKey() string {
+	return r.Name() + ":" + r.Tag
 }
 
 // String returns the full image reference string.
 func (r ImageReference) String() string {
-	return r.Registry + "/" + r.Repository + ":" + r.Tag
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// ManifestRef returns what identifies the manifest in registry requests:
+// the digest if the reference is pinned, otherwise the tag.
+func (r ImageReference) ManifestRef() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// ValidateDigest checks that digest is a well-formed "sha256:<64 hex>" digest.
+func ValidateDigest(digest string) error {
+	hex, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm: %s", digest)
+	}
+	if len(hex) != 64 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return fmt.Errorf("malformed digest: %s", digest)
+	}
+	return nil
 }
@@ -0,0 +1,154 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+// testDigest is a well-formed digest used throughout the reference tests.
+var testDigest = "sha256:" + strings.Repeat("ab", 32)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref        string
+		registry   string
+		repository string
+		tag        string
+		digest     string
+		name       string
+	}{
+		{"alpine", DefaultRegistry, "library/alpine", "latest", "", "alpine"},
+		{"alpine:3.19", DefaultRegistry, "library/alpine", "3.19", "", "alpine"},
+		{"docker.io/library/alpine", DefaultRegistry, "library/alpine", "latest", "", "alpine"},
+		{"index.docker.io/library/alpine:edge", DefaultRegistry, "library/alpine", "edge", "", "alpine"},
+		{"registry-1.docker.io/library/alpine", DefaultRegistry, "library/alpine", "latest", "", "alpine"},
+		{"myuser/myapp", DefaultRegistry, "myuser/myapp", "latest", "", "myuser/myapp"},
+		{"ghcr.io/owner/team/repo:v1", "ghcr.io", "owner/team/repo", "v1", "", "ghcr.io/owner/team/repo"},
+		{"localhost:5000/app", "localhost:5000", "app", "latest", "", "localhost:5000/app"},
+		{"localhost/app:dev", "localhost", "app", "dev", "", "localhost/app"},
+		{"alpine@" + testDigest, DefaultRegistry, "library/alpine", "", testDigest, "alpine"},
+		{"alpine:3.19@" + testDigest, DefaultRegistry, "library/alpine", "3.19", testDigest, "alpine"},
+		{"localhost:5000/app@" + testDigest, "localhost:5000", "app", "", testDigest, "localhost:5000/app"},
+		{"myuser/my_app.v2/sub__dir/a-b--c", DefaultRegistry, "myuser/my_app.v2/sub__dir/a-b--c", "latest", "", "myuser/my_app.v2/sub__dir/a-b--c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := ParseReference(tt.ref)
+			if err != nil {
+				t.Fatalf("ParseReference(%q): %v", tt.ref, err)
+			}
+			want := ImageReference{Registry: tt.registry, Repository: tt.repository, Tag: tt.tag, Digest: tt.digest}
+			if got != want {
+				t.Errorf("ParseReference(%q) = %+v, want %+v", tt.ref, got, want)
+			}
+			if name := got.Name(); name != tt.name {
+				t.Errorf("ParseReference(%q).Name() = %q, want %q", tt.ref, name, tt.name)
+			}
+		})
+	}
+}
+
+func TestParseReferenceInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"Alpine",
+		"alpine:",
+		"alpine:-bad",
+		"alpine:" + strings.Repeat("t", 129),
+		"alpine@sha256:abc",
+		"alpine@md5:" + strings.Repeat("ab", 16),
+		"alpine@sha256:" + strings.Repeat("AB", 32),
+		"alpine@sha256:" + strings.Repeat("../", 19) + "etc/pas",
+		"alpine/",
+		"/alpine",
+		"a//b",
+		"-app",
+		"app-",
+		"a..b",
+		"a___b",
+		"../../etc",
+		"bad_host.io:5000/app",
+		"ghcr.io/" + strings.Repeat("a", maxNameLength),
+	}
+
+	for _, ref := range tests {
+		t.Run(ref, func(t *testing.T) {
+			if got, err := ParseReference(ref); err == nil {
+				t.Errorf("ParseReference(%q) = %+v, want an error", ref, got)
+			}
+		})
+	}
+}
+
+func TestParseTagReferenceRejectsDigest(t *testing.T) {
+	if _, err := ParseTagReference("alpine@" + testDigest); err == nil {
+		t.Error("ParseTagReference accepted a digest")
+	}
+	if _, err := ParseTagReference("alpine:3.19"); err != nil {
+		t.Errorf("ParseTagReference(alpine:3.19): %v", err)
+	}
+}
+
+func TestValidateDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		digest string
+		valid  bool
+	}{
+		{"valid", testDigest, true},
+		{"all hex digits", "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"empty", "", false},
+		{"no algorithm", strings.Repeat("ab", 32), false},
+		{"other algorithm", "sha512:" + strings.Repeat("ab", 32), false},
+		{"short", "sha256:" + strings.Repeat("ab", 31), false},
+		{"long", "sha256:" + strings.Repeat("ab", 33), false},
+		{"uppercase hex", "sha256:" + strings.Repeat("AB", 32), false},
+		{"non-hex", "sha256:" + strings.Repeat("zz", 32), false},
+		// Digests name files in the image store: none of these may get through
+		{"path traversal", "sha256:" + strings.Repeat("../", 19) + "etc/pas", false},
+		{"absolute path", "sha256:/" + strings.Repeat("a", 63), false},
+		{"slash", "sha256:" + strings.Repeat("a", 31) + "/" + strings.Repeat("a", 32), false},
+		{"dot dot", "sha256:.." + strings.Repeat("a", 62), false},
+		{"NUL byte", "sha256:" + strings.Repeat("a", 63) + "\x00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDigest(tt.digest)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateDigest(%q) = %v, want valid=%v", tt.digest, err, tt.valid)
+			}
+			// The streaming verifier accepts exactly the same digests
+			_, err = newDigestVerifier(tt.digest)
+			if (err == nil) != tt.valid {
+				t.Errorf("newDigestVerifier(%q) = %v, want valid=%v", tt.digest, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateManifestDigests(t *testing.T) {
+	traversal := "sha256:" + strings.Repeat("../", 19) + "etc/pas"
+	manifest := func(config, layer string) *ManifestV2 {
+		return &ManifestV2{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeOCIManifest,
+			Config:        Descriptor{MediaType: MediaTypeOCIConfig, Digest: config},
+			Layers:        []Descriptor{{MediaType: MediaTypeOCILayerGzip, Digest: layer}},
+		}
+	}
+
+	if err := validateManifest(manifest(testDigest, testDigest), ""); err != nil {
+		t.Errorf("valid manifest rejected: %v", err)
+	}
+	if err := validateManifest(manifest(traversal, testDigest), ""); err == nil {
+		t.Error("manifest with a traversal config digest accepted")
+	}
+	if err := validateManifest(manifest(testDigest, traversal), ""); err == nil {
+		t.Error("manifest with a traversal layer digest accepted")
+	}
+	if err := validateDiffIDs([]string{testDigest, traversal}); err == nil {
+		t.Error("config with a traversal diff_id accepted")
+	}
+}
@@ -0,0 +1,208 @@
+package image
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultRegistriesConfigPath is where per-registry settings are read from.
+// Override with the MINICONTAINER_REGISTRIES_CONFIG environment variable.
+const DefaultRegistriesConfigPath = "/etc/minicontainer/registries.json"
+
+// DefaultCertsDir holds per-registry TLS material, one directory per host:
+//
+//	/etc/minicontainer/certs.d/<host[:port]>/ca.crt       CA bundle(s) to trust (*.crt)
+//	/etc/minicontainer/certs.d/<host[:port]>/client.cert  Client certificate for mTLS (*.cert)
+//	/etc/minicontainer/certs.d/<host[:port]>/client.key   Matching private key (*.key)
+//
+// The layout matches Docker's /etc/docker/certs.d, so existing directories can be reused.
+const DefaultCertsDir = "/etc/minicontainer/certs.d"
+
+// RegistriesConfig holds settings that apply to specific registries.
+// Example /etc/minicontainer/registries.json:
+//
+//	{
+//	  "insecure_registries": ["registry.internal:5000"],
+//	  "certs_dir": "/etc/minicontainer/certs.d",
+//	  "mirrors": {"docker.io": ["localhost:5000", "mirror.gcr.io"]}
+//	}
+type RegistriesConfig struct {
+	// InsecureRegistries may be reached over plain HTTP or HTTPS without
+	// certificate verification. Entries are "host[:port]" or CIDR ranges.
+	// Loopback registries (localhost, 127.0.0.0/8, ::1) are always insecure.
+	InsecureRegistries []string `json:"insecure_registries"`
+
+	// CertsDir overrides DefaultCertsDir.
+	CertsDir string `json:"certs_dir"`
+
+	// Mirrors lists pull-through mirrors per upstream registry, tried in
+	// order before the registry itself when pulling. Keys and mirrors are
+	// "host[:port]"; "docker.io" stands for Docker Hub. Mirrors follow the
+	// same TLS and insecure settings as any other registry, so a plain-HTTP
+	// mirror on localhost works as is.
+	Mirrors map[string][]string `json:"mirrors"`
+}
+
+// LoadRegistriesConfig reads the registries config. A missing file yields defaults.
+func LoadRegistriesConfig() (*RegistriesConfig, error) {
+	path := os.Getenv("MINICONTAINER_REGISTRIES_CONFIG")
+	if path == "" {
+		path = DefaultRegistriesConfigPath
+	}
+
+	cfg := &RegistriesConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			cfg.CertsDir = DefaultCertsDir
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("read registries config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse registries config %s: %w", path, err)
+	}
+	if cfg.CertsDir == "" {
+		cfg.CertsDir = DefaultCertsDir
+	}
+	for upstream, mirrors := range cfg.Mirrors {
+		for _, mirror := range append([]string{upstream}, mirrors...) {
+			if !registryRegexp.MatchString(mirror) {
+				return nil, fmt.Errorf("registries config %s: invalid mirror registry %q (want host[:port])", path, mirror)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// MirrorsFor returns the mirrors of registry, in the order they are tried.
+// Docker Hub mirrors may be listed under any of its names.
+func (c *RegistriesConfig) MirrorsFor(registry string) []string {
+	var mirrors []string
+	for _, upstream := range slices.Sorted(maps.Keys(c.Mirrors)) {
+		if upstream == registry || (isDockerHub(upstream) && isDockerHub(registry)) {
+			mirrors = append(mirrors, c.Mirrors[upstream]...)
+		}
+	}
+	return mirrors
+}
+
+// IsInsecure reports whether registry may be used without verified TLS.
+func (c *RegistriesConfig) IsInsecure(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+
+	// Loopback registries are trusted implicitly, like Docker does
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	for _, entry := range c.InsecureRegistries {
+		if entry == registry || entry == host {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil && ip != nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRegistryHTTPClient builds an HTTP client for talking to registry.
+// Trusts the system roots plus any CA bundles in the registry's certs.d
+// directory, presents client certificates found there (mTLS), and skips
+// certificate verification for insecure registries.
+//
+// Returns the client and whether the registry is insecure (plain HTTP allowed).
+func newRegistryHTTPClient(registry string) (*http.Client, bool, error) {
+	cfg, err := LoadRegistriesConfig()
+	if err != nil {
+		return nil, false, err
+	}
+	insecure := cfg.IsInsecure(registry)
+
+	tlsConfig, err := loadRegistryTLS(filepath.Join(cfg.CertsDir, registry))
+	if err != nil {
+		return nil, false, err
+	}
+	tlsConfig.InsecureSkipVerify = insecure
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, insecure, nil
+}
+
+// loadRegistryTLS reads CA bundles (*.crt) and client key pairs (*.cert + *.key)
+// from a registry's certs directory. A missing directory yields system defaults.
+func loadRegistryTLS(dir string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tlsConfig, nil
+		}
+		return nil, fmt.Errorf("read certs dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+
+		switch {
+		case strings.HasSuffix(name, ".crt"):
+			// Additional CA to trust on top of the system roots
+			if tlsConfig.RootCAs == nil {
+				pool, err := x509.SystemCertPool()
+				if err != nil {
+					pool = x509.NewCertPool()
+				}
+				tlsConfig.RootCAs = pool
+			}
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read CA %s: %w", path, err)
+			}
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", path)
+			}
+
+		case strings.HasSuffix(name, ".cert"):
+			// Client certificate: requires a key with the same base name
+			keyName := strings.TrimSuffix(name, ".cert") + ".key"
+			if !slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == keyName }) {
+				return nil, fmt.Errorf("missing key %s for client certificate %s", keyName, path)
+			}
+			pair, err := tls.LoadX509KeyPair(path, filepath.Join(dir, keyName))
+			if err != nil {
+				return nil, fmt.Errorf("load client certificate %s: %w", path, err)
+			}
+			tlsConfig.Certificates = append(tlsConfig.Certificates, pair)
+
+		case strings.HasSuffix(name, ".key"):
+			// Validated together with its .cert above
+			certName := strings.TrimSuffix(name, ".key") + ".cert"
+			if !slices.ContainsFunc(entries, func(e os.DirEntry) bool { return e.Name() == certName }) {
+				return nil, fmt.Errorf("missing client certificate %s for key %s", certName, path)
+			}
+		}
+	}
+
+	return tlsConfig, nil
+}
@@ -1,28 +1,46 @@
 package image
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Descriptor references a blob by media type, digest and size.
+type Descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	URLs      []string `json:"urls,omitempty"` // Where non-distributable layers can be downloaded
+}
+
 // ManifestV2 represents an OCI/Docker image manifest (schema v2).
-// Contains references to the config and layer blobs.
+// Contains references to the config and layer blobs. OCI artifacts such as
+// image signatures use the same format with an artifact type and a subject.
 type ManifestV2 struct {
-	SchemaVersion int    `json:"schemaVersion"`
-	MediaType     string `json:"mediaType"`
-	Config        struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int64  `json:"size"`
-	} `json:"config"`
-	Layers []struct {
-		MediaType string `json:"mediaType"`
-		Digest    string `json:"digest"`
-		Size      int64  `json:"size"`
-	} `json:"layers"`
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	ArtifactType  string       `json:"artifactType,omitempty"` // Kind of artifact (empty for images)
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+	Subject       *Descriptor  `json:"subject,omitempty"` // Manifest an artifact refers to
+}
+
+// RemoteManifest is the image manifest a reference resolved to, with the
+// document exactly as the registry served it.
+type RemoteManifest struct {
+	*ManifestV2
+	Raw        []byte   // The manifest as served; stored in the content store as is
+	Digest     string   // Digest of Raw
+	Platform   Platform // Platform of the selected index entry (zero value for direct manifests)
+	RepoDigest string   // Digest of the document the reference points to: the index, or the manifest itself
 }
 
 // ManifestList represents a multi-architecture manifest list.
@@ -37,6 +55,7 @@ type ManifestList struct {
 		Platform  struct {
 			Architecture string `json:"architecture"`
 			OS           string `json:"os"`
+			Variant      string `json:"variant,omitempty"`
 		} `json:"platform"`
 	} `json:"manifests"`
 }
@@ -44,39 +63,112 @@ type ManifestList struct {
 // ImageConfig represents the OCI image configuration.
 // Contains runtime settings like Env, Cmd, Entrypoint.
 type ImageConfig struct {
-	Config struct {
-		Env        []string `json:"Env"`
-		Cmd        []string `json:"Cmd"`
-		Entrypoint []string `json:"Entrypoint"`
-		WorkingDir string   `json:"WorkingDir"`
-		User       string   `json:"User"`
+	Architecture string `json:"architecture"`      // CPU architecture (e.g., "amd64")
+	OS           string `json:"os"`                // Operating system (e.g., "linux")
+	Variant      string `json:"variant,omitempty"` // CPU variant (e.g., "v7")
+	Config       struct {
+		Env          []string            `json:"Env"`
+		Cmd          []string            `json:"Cmd"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		WorkingDir   string              `json:"WorkingDir"`
+		User         string              `json:"User"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"` // e.g. {"80/tcp": {}}
+		Labels       map[string]string   `json:"Labels,omitempty"`
 	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`     // Always "layers"
+		DiffIDs []string `json:"diff_ids"` // Uncompressed layer digests, bottom to top
+	} `json:"rootfs"`
+	Created *time.Time     `json:"created,omitempty"` // When the image was created
+	History []HistoryEntry `json:"history,omitempty"` // How each layer was created, bottom to top
+}
+
+// HistoryEntry describes one step in an image's history.
+// Entries with EmptyLayer set changed only the config and have no layer.
+type HistoryEntry struct {
+	Created    *time.Time `json:"created,omitempty"`     // When the step ran
+	CreatedBy  string     `json:"created_by,omitempty"`  // Command that created the layer
+	Author     string     `json:"author,omitempty"`      // Author of the step
+	Comment    string     `json:"comment,omitempty"`     // Commit message
+	EmptyLayer bool       `json:"empty_layer,omitempty"` // Step did not add a layer
 }
 
+// tokenRefreshMargin is how long before expiry a bearer token is renewed,
+// so long-running pulls never send a request with an expired token.
+const tokenRefreshMargin = 30 * time.Second
+
+// defaultTokenLifetime applies when a token response omits expires_in
+// (the distribution spec's documented default).
+const defaultTokenLifetime = 60 * time.Second
+
 // RegistryClient handles communication with OCI registries.
+// Safe for concurrent use: parallel blob downloads share one token.
 type RegistryClient struct {
-	ref    ImageReference
-	token  string       // Bearer token for authentication
-	client *http.Client // HTTP client for requests
+	ref      ImageReference
+	client   *http.Client // HTTP client for requests (TLS settings from certs.d)
+	endpoint string       // Base URL: "https://<registry>", or "http://" for insecure registries without TLS
+	insecure bool         // Registry allows plain HTTP / unverified TLS
+	username string       // Registry username (empty for anonymous access)
+	password string       // Registry password or access token
+	scheme   string       // Auth scheme required by the registry: "", "Basic" or "Bearer"
+	realm    string       // Bearer token endpoint (from WWW-Authenticate)
+	service  string       // Bearer token service (from WWW-Authenticate)
+	actions  string       // Repository actions requested in the token scope: "pull" or "push,pull"
+
+	mu        sync.Mutex // Guards token and expiresAt
+	token     string     // Bearer token for authentication
+	expiresAt time.Time  // When token stops being valid
+
+	mirrors  []*RegistryClient // Pull-through mirrors tried before the registry (see UseMirrors)
+	authOnce sync.Once         // Authenticates on first use when mirrors are in use
+	authErr  error             // Outcome of that authentication
+	servedMu sync.Mutex        // Guards served
+	served   map[string]string // Digest -> registry (or URL host) that served it, with mirrors only
 }
 
 // NewRegistryClient creates a client for the given image reference.
-func NewRegistryClient(ref ImageReference) *RegistryClient {
-	return &RegistryClient{
-		ref:    ref,
-		client: &http.Client{},
+// Credentials saved by `minicontainer login` are picked up automatically, and
+// TLS trust and insecure access follow the registries config.
+func NewRegistryClient(ref ImageReference) (*RegistryClient, error) {
+	httpClient, insecure, err := newRegistryHTTPClient(ref.Registry)
+	if err != nil {
+		return nil, fmt.Errorf("configure registry %s: %w", ref.Registry, err)
+	}
+
+	c := &RegistryClient{
+		ref:      ref,
+		client:   httpClient,
+		endpoint: "https://" + ref.Registry,
+		insecure: insecure,
+		actions:  "pull",
 	}
+	c.username, c.password, _ = LookupCredentials(ref.Registry)
+	return c, nil
+}
+
+// SetCredentials overrides the stored credentials (used by `login` to verify new ones).
+func (c *RegistryClient) SetCredentials(username, password string) {
+	c.username = username
+	c.password = password
 }
 
-// Authenticate obtains a bearer token for the registry.
-// For Docker Hub, this involves:
+// Authenticate prepares the client to talk to the registry.
+// For Docker Hub and most registries, this involves:
 //  1. Request to registry returns 401 with WWW-Authenticate header
-//  2. Parse header to get realm, service, scope
-//  3. Request token from auth endpoint (anonymous for public images)
+//  2. Parse header to get the scheme, realm and service
+//  3. For Bearer, request a token from the auth endpoint, sending stored
+//     credentials as Basic auth (anonymous for public images)
+//
+// Registries using Basic auth get credentials attached to every request instead.
+// Insecure registries that don't speak TLS are retried over plain HTTP.
 func (c *RegistryClient) Authenticate() error {
 	// Step 1: Make initial request to trigger 401
-	url := fmt.Sprintf("https://%s/v2/", c.ref.Registry)
-	resp, err := c.client.Get(url)
+	resp, err := c.client.Get(c.endpoint + "/v2/")
+	if err != nil && c.insecure {
+		// Insecure registry without TLS: fall back to plain HTTP
+		c.endpoint = "http://" + c.ref.Registry
+		resp, err = c.client.Get(c.endpoint + "/v2/")
+	}
 	if err != nil {
 		return fmt.Errorf("registry ping: %w", err)
 	}
@@ -99,21 +191,76 @@ func (c *RegistryClient) Authenticate() error {
 		return fmt.Errorf("missing WWW-Authenticate header")
 	}
 
-	realm, service := parseAuthHeader(authHeader)
-	if realm == "" {
-		return fmt.Errorf("could not parse auth header: %s", authHeader)
+	scheme, realm, service := parseAuthHeader(authHeader)
+	switch scheme {
+	case "Basic":
+		if c.username == "" {
+			return fmt.Errorf("registry %s requires credentials: run 'minicontainer login %s'", c.ref.Registry, c.ref.Registry)
+		}
+		c.scheme = scheme
+		return c.checkBasicAuth()
+
+	case "Bearer":
+		if realm == "" {
+			return fmt.Errorf("could not parse auth header: %s", authHeader)
+		}
+		c.scheme, c.realm, c.service = scheme, realm, service
+
+		// Step 3: Request token
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.refreshToken()
+
+	default:
+		return fmt.Errorf("unsupported auth scheme: %s", authHeader)
 	}
+}
 
-	// Step 3: Request token
-	scope := fmt.Sprintf("repository:%s:pull", c.ref.Repository)
-	tokenURL := fmt.Sprintf("%s?service=%s&scope=%s", realm, service, scope)
+// checkBasicAuth verifies Basic credentials against the registry's /v2/ endpoint.
+func (c *RegistryClient) checkBasicAuth() error {
+	resp, err := c.doRequest("GET", c.endpoint+"/v2/")
+	if err != nil {
+		return fmt.Errorf("registry ping: %w", err)
+	}
+	defer resp.Body.Close()
 
-	tokenResp, err := c.client.Get(tokenURL)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login to %s failed: %d", c.ref.Registry, resp.StatusCode)
+	}
+	return nil
+}
+
+// refreshToken requests a new bearer token for the repository.
+// Credentials, if any, are sent as Basic auth. Both "token" and
+// "access_token" response fields are accepted, as the spec allows either.
+// Must be called with c.mu held.
+func (c *RegistryClient) refreshToken() error {
+	query := neturl.Values{}
+	if c.service != "" {
+		query.Set("service", c.service)
+	}
+	if c.ref.Repository != "" {
+		query.Set("scope", fmt.Sprintf("repository:%s:%s", c.ref.Repository, c.actions))
+	}
+	tokenURL := c.realm + "?" + query.Encode()
+
+	req, err := http.NewRequest("GET", tokenURL, nil)
+	if err != nil {
+		return fmt.Errorf("create token request: %w", err)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	tokenResp, err := c.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("token request: %w", err)
 	}
 	defer tokenResp.Body.Close()
 
+	if tokenResp.StatusCode == http.StatusUnauthorized && c.username != "" {
+		return fmt.Errorf("login to %s failed: invalid username or password", c.ref.Registry)
+	}
 	if tokenResp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(tokenResp.Body)
 		return fmt.Errorf("token request failed: %d: %s", tokenResp.StatusCode, body)
@@ -121,40 +268,61 @@ func (c *RegistryClient) Authenticate() error {
 
 	// Parse token response
 	var tokenData struct {
-		Token string `json:"token"`
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"` // Seconds
+		IssuedAt    time.Time `json:"issued_at"`
 	}
 	if err := json.NewDecoder(tokenResp.Body).Decode(&tokenData); err != nil {
 		return fmt.Errorf("parse token response: %w", err)
 	}
 
 	c.token = tokenData.Token
+	if c.token == "" {
+		c.token = tokenData.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("token response contained no token")
+	}
+
+	lifetime := defaultTokenLifetime
+	if tokenData.ExpiresIn > 0 {
+		lifetime = time.Duration(tokenData.ExpiresIn) * time.Second
+	}
+	issued := tokenData.IssuedAt
+	if issued.IsZero() || issued.After(time.Now()) {
+		issued = time.Now()
+	}
+	c.expiresAt = issued.Add(lifetime)
 	return nil
 }
 
 // FetchManifest retrieves the image manifest from the registry.
 // Handles both direct manifests and manifest lists (multi-arch).
-func (c *RegistryClient) FetchManifest() (*ManifestV2, error) {
-	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s",
-		c.ref.Registry, c.ref.Repository, c.ref.Tag)
+// For manifest lists, the entry matching platform is selected.
+//
+// If the reference is pinned by digest, the manifest (or manifest list) is
+// fetched by that digest and its content is verified against it.
+//
+// Returns:
+//   - the image manifest, with the platform of the selected list entry (zero
+//     value for direct manifests, whose platform is only known from the image
+//     config) and the repo digest: digest of the manifest or manifest list
+//     the reference points to
+//   - error if the request fails, the digest does not match or no entry matches platform
+func (c *RegistryClient) FetchManifest(platform Platform) (*RemoteManifest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s",
+		c.endpoint, c.ref.Repository, c.ref.ManifestRef())
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create manifest request: %w", err)
 	}
 
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
-	}
-
-	// Accept manifest list and direct manifests
-	req.Header.Set("Accept", strings.Join([]string{
-		"application/vnd.docker.distribution.manifest.list.v2+json",
-		"application/vnd.oci.image.index.v1+json",
-		"application/vnd.docker.distribution.manifest.v2+json",
-		"application/vnd.oci.image.manifest.v1+json",
-	}, ", "))
+	// Accept indexes and manifests in both Docker and OCI formats
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
 
-	resp, err := c.client.Do(req)
+	resp, source, err := c.fetch(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch manifest: %w", err)
 	}
@@ -171,29 +339,41 @@ func (c *RegistryClient) FetchManifest() (*ManifestV2, error) {
 		return nil, fmt.Errorf("read manifest body: %w", err)
 	}
 
-	// Check if it's a manifest list
+	// The repo digest identifies exactly this document
+	repoDigest := digestBytes(body)
+	if c.ref.Digest != "" && repoDigest != c.ref.Digest {
+		return nil, fmt.Errorf("manifest digest mismatch: expected %s, got %s", c.ref.Digest, repoDigest)
+	}
+	c.recordServed(repoDigest, source)
+
+	// Check if it's a manifest list or index
 	contentType := resp.Header.Get("Content-Type")
-	if strings.Contains(contentType, "manifest.list") || strings.Contains(contentType, "image.index") {
-		// Parse as manifest list, find amd64/linux manifest
+	isIndex, err := isIndexDocument(body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if isIndex {
+		// Parse as manifest list, find the manifest for the requested platform
 		var list ManifestList
 		if err := json.Unmarshal(body, &list); err != nil {
 			return nil, fmt.Errorf("parse manifest list: %w", err)
 		}
-
-		// Find amd64/linux manifest
-		var digest string
-		for _, m := range list.Manifests {
-			if m.Platform.Architecture == "amd64" && m.Platform.OS == "linux" {
-				digest = m.Digest
-				break
-			}
+		if err := validateIndex(&list, contentType); err != nil {
+			return nil, err
 		}
-		if digest == "" {
-			return nil, fmt.Errorf("no amd64/linux manifest found")
+
+		digest, selected, ok := selectPlatform(&list, platform)
+		if !ok {
+			return nil, fmt.Errorf("no manifest found for platform %s", platform)
 		}
 
 		// Fetch the actual manifest by digest
-		return c.fetchManifestByDigest(digest)
+		manifest, err := c.fetchManifestByDigest(digest)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Platform, manifest.RepoDigest = selected, repoDigest
+		return manifest, nil
 	}
 
 	// Parse as direct manifest
@@ -201,8 +381,37 @@ func (c *RegistryClient) FetchManifest() (*ManifestV2, error) {
 	if err := json.Unmarshal(body, &manifest); err != nil {
 		return nil, fmt.Errorf("parse manifest: %w", err)
 	}
+	if err := validateManifest(&manifest, contentType); err != nil {
+		return nil, err
+	}
 
-	return &manifest, nil
+	return &RemoteManifest{ManifestV2: &manifest, Raw: body, Digest: repoDigest, RepoDigest: repoDigest}, nil
+}
+
+// isIndexDocument reports whether a manifest response is a manifest list or
+// OCI index rather than an image manifest. The mediaType field and the
+// Content-Type are checked; an OCI index may omit both, so a document with
+// a "manifests" array also counts as one.
+func isIndexDocument(body []byte, contentType string) (bool, error) {
+	var probe struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return false, fmt.Errorf("parse manifest: %w", err)
+	}
+	mediaType, err := documentMediaType(probe.MediaType, contentType)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case isIndexMediaType(mediaType):
+		return true, nil
+	case isManifestMediaType(mediaType):
+		return false, nil
+	default:
+		return probe.Manifests != nil, nil
+	}
 }
 
 // FetchBlob downloads a blob (layer or config) by digest.
@@ -217,10 +426,14 @@ func (c *RegistryClient) FetchManifest() (*ManifestV2, error) {
 //   - error: any error during fetch
 func (c *RegistryClient) FetchBlob(digest string) (io.ReadCloser, int64, error) {
 	// Build blob URL: /v2/<repo>/blobs/<digest>
-	url := fmt.Sprintf("https://%s/v2/%s/blobs/%s",
-		c.ref.Registry, c.ref.Repository, digest)
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s",
+		c.endpoint, c.ref.Repository, digest)
 
-	resp, err := c.doRequest("GET", url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("create blob request: %w", err)
+	}
+	resp, source, err := c.fetch(req)
 	if err != nil {
 		return nil, 0, fmt.Errorf("fetch blob: %w", err)
 	}
@@ -231,34 +444,387 @@ func (c *RegistryClient) FetchBlob(digest string) (io.ReadCloser, int64, error)
 		return nil, 0, fmt.Errorf("blob request failed: %d: %s", resp.StatusCode, body)
 	}
 
+	c.recordServed(digest, source)
 	return resp.Body, resp.ContentLength, nil
 }
 
+// FetchBlobRange downloads a blob starting at the given byte offset.
+// Used to resume interrupted downloads with an HTTP Range request.
+// Registries that ignore Range reply with the full blob; resumed reports
+// which happened so the caller can discard its partial data.
+//
+// Parameters:
+//   - digest: the "sha256:..." digest of the blob
+//   - offset: number of bytes already downloaded (0 for a full download)
+//
+// Returns:
+//   - io.ReadCloser: blob content stream from offset (or from 0 if not resumed)
+//   - bool: true if the server honored the range
+//   - int64: total blob size (-1 if unknown)
+//   - error: any error during fetch
+func (c *RegistryClient) FetchBlobRange(digest string, offset int64) (io.ReadCloser, bool, int64, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s",
+		c.endpoint, c.ref.Repository, digest)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("create blob request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, source, err := c.fetch(req)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("fetch blob: %w", err)
+	}
+	body, resumed, total, err := rangeResponse(resp, offset)
+	if err == nil {
+		c.recordServed(digest, source)
+	}
+	return body, resumed, total, err
+}
+
+// FetchLayerRange downloads a layer blob starting at the given byte offset,
+// like FetchBlobRange. Non-distributable (foreign) layers are often not
+// stored by the registry: if it cannot serve one, the layer is downloaded
+// from the URLs in its descriptor instead, in order, without registry credentials.
+//
+// Parameters:
+//   - layer: the layer descriptor from the manifest
+//   - offset: number of bytes already downloaded (0 for a full download)
+//
+// Returns the same values as FetchBlobRange.
+func (c *RegistryClient) FetchLayerRange(layer Descriptor, offset int64) (io.ReadCloser, bool, int64, error) {
+	body, resumed, total, err := c.FetchBlobRange(layer.Digest, offset)
+	if err == nil {
+		return body, resumed, total, nil
+	}
+	lt, _ := parseLayerMediaType(layer.MediaType)
+	if !lt.foreign {
+		return nil, false, 0, err
+	}
+	if len(layer.URLs) == 0 {
+		return nil, false, 0, fmt.Errorf("non-distributable layer not available from the registry and has no URLs: %w", err)
+	}
+
+	for _, u := range layer.URLs {
+		body, resumed, total, urlErr := c.fetchURLRange(u, offset)
+		if urlErr == nil {
+			if parsed, err := neturl.Parse(u); err == nil {
+				c.recordServed(layer.Digest, parsed.Host)
+			}
+			return body, resumed, total, nil
+		}
+		err = fmt.Errorf("%w; %s: %w", err, u, urlErr)
+	}
+	return nil, false, 0, err
+}
+
+// fetchURLRange downloads a foreign layer from an external URL starting at
+// offset. Only http and https URLs are followed.
+func (c *RegistryClient) fetchURLRange(url string, offset int64) (io.ReadCloser, bool, int64, error) {
+	u, err := neturl.Parse(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false, 0, fmt.Errorf("unsupported layer URL")
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("create layer request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("fetch layer: %w", err)
+	}
+	return rangeResponse(resp, offset)
+}
+
+// rangeResponse interprets the response to a blob request sent with an
+// optional Range header starting at offset. See FetchBlobRange for the results.
+func rangeResponse(resp *http.Response, offset int64) (io.ReadCloser, bool, int64, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, false, resp.ContentLength, nil
+
+	case http.StatusPartialContent:
+		// Content-Range: bytes <start>-<end>/<total>
+		total := int64(-1)
+		if _, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+				total = n
+			}
+		}
+		return resp.Body, true, total, nil
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial download already holds the whole blob
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), true, offset, nil
+
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, false, 0, fmt.Errorf("blob request failed: %d: %s", resp.StatusCode, body)
+	}
+}
+
 // FetchConfig downloads and parses the image configuration.
 // The config contains runtime settings (Env, Cmd, Entrypoint, etc.)
-func (c *RegistryClient) FetchConfig(digest string) (*ImageConfig, error) {
+// The blob is verified against its digest before it is parsed.
+// Returns the parsed config and the blob as served.
+func (c *RegistryClient) FetchConfig(digest string) (*ImageConfig, []byte, error) {
+	verifier, err := newDigestVerifier(digest)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	body, _, err := c.FetchBlob(digest)
 	if err != nil {
-		return nil, fmt.Errorf("fetch config blob: %w", err)
+		return nil, nil, fmt.Errorf("fetch config blob: %w", err)
 	}
 	defer body.Close()
 
+	data, err := io.ReadAll(io.TeeReader(body, verifier))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read config blob: %w", err)
+	}
+	if err := verifier.Verify(); err != nil {
+		return nil, nil, fmt.Errorf("config blob: %w", err)
+	}
+
 	var config ImageConfig
-	if err := json.NewDecoder(body).Decode(&config); err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	return &config, data, nil
+}
+
+// uploadChunkSize is the largest blob sent in a single monolithic PUT.
+// Bigger blobs are uploaded in PATCH chunks of this size.
+const uploadChunkSize = 16 << 20
+
+// BlobExists reports whether the repository already has a blob (HEAD check).
+// Used by push to skip layers the registry already stores.
+func (c *RegistryClient) BlobExists(digest string) (bool, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s",
+		c.endpoint, c.ref.Repository, digest)
+
+	resp, err := c.doRequest("HEAD", url)
+	if err != nil {
+		return false, fmt.Errorf("check blob: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("blob check failed: %d", resp.StatusCode)
 	}
+}
+
+// UploadBlob uploads a blob to the repository using the registry upload protocol:
+//  1. POST /v2/<repo>/blobs/uploads/ starts an upload session (202 + Location)
+//  2. Blobs up to uploadChunkSize are sent in one PUT <location>?digest=<digest>;
+//     larger blobs are sent as PATCH chunks with Content-Range, each response
+//     giving the Location for the next request, then closed with an empty PUT
+//
+// Parameters:
+//   - digest: the "sha256:..." digest of the blob (verified by the registry)
+//   - size: blob size in bytes
+//   - r: blob content
+//   - progress: optional writer counting uploaded bytes (may be nil)
+//
+// Returns:
+//   - error: any error during upload
+func (c *RegistryClient) UploadBlob(digest string, size int64, r io.Reader, progress io.Writer) error {
+	// Step 1: Start the upload session
+	location, err := c.startUpload()
+	if err != nil {
+		return err
+	}
+	if progress == nil {
+		progress = io.Discard
+	}
+
+	// Step 2: Send the data in chunks; a small blob is a single monolithic PUT
+	buf := make([]byte, min(size, uploadChunkSize))
+	var offset int64
+	for size > uploadChunkSize && offset < size {
+		n, err := io.ReadFull(r, buf[:min(size-offset, uploadChunkSize)])
+		if err != nil {
+			return fmt.Errorf("read blob: %w", err)
+		}
+
+		req, err := http.NewRequest("PATCH", location, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return fmt.Errorf("create upload request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+
+		resp, err := c.do(req)
+		if err != nil {
+			return fmt.Errorf("upload chunk: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("upload chunk failed: %d", resp.StatusCode)
+		}
+		if location, err = c.resolveLocation(resp.Header.Get("Location")); err != nil {
+			return err
+		}
+
+		offset += int64(n)
+		progress.Write(buf[:n])
+	}
+
+	// Step 3: Complete the upload, sending the whole blob if it was not chunked
+	var body []byte
+	if offset == 0 {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("read blob: %w", err)
+		}
+		body = buf
+	}
+
+	u, err := neturl.Parse(location)
+	if err != nil {
+		return fmt.Errorf("parse upload location: %w", err)
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
 
-	return &config, nil
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("complete upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("complete upload failed: %d: %s", resp.StatusCode, msg)
+	}
+	progress.Write(body)
+	return nil
 }
 
-// parseAuthHeader extracts realm and service from WWW-Authenticate header.
+// startUpload opens a blob upload session and returns its absolute URL.
+func (c *RegistryClient) startUpload() (string, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/uploads/", c.endpoint, c.ref.Repository)
+
+	resp, err := c.doRequest("POST", url)
+	if err != nil {
+		return "", fmt.Errorf("start upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("start upload failed: %d: %s", resp.StatusCode, msg)
+	}
+	return c.resolveLocation(resp.Header.Get("Location"))
+}
+
+// resolveLocation turns an upload Location header, which registries may send
+// as a path relative to the registry, into an absolute URL.
+func (c *RegistryClient) resolveLocation(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("registry did not return an upload location")
+	}
+	base, err := neturl.Parse(c.endpoint + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := neturl.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("parse upload location: %w", err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// PutManifest uploads a manifest under the given tag.
+// Returns the manifest digest reported by the registry (or computed locally).
+func (c *RegistryClient) PutManifest(tag, mediaType string, manifest []byte) (string, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", c.endpoint, c.ref.Repository, tag)
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(manifest))
+	if err != nil {
+		return "", fmt.Errorf("create manifest request: %w", err)
+	}
+	req.Header.Set("Content-Type", mediaType)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("put manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("put manifest failed: %d: %s", resp.StatusCode, msg)
+	}
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return digestBytes(manifest), nil
+}
+
+// selectPlatform picks the manifest list entry for the requested platform.
+// Only image manifest entries are considered.
+// An entry whose variant matches exactly is preferred over one that merely
+// omits its variant (see Platform.Matches).
+//
+// Returns the entry's digest, its platform, and whether a match was found.
+func selectPlatform(list *ManifestList, want Platform) (string, Platform, bool) {
+	var fallback string
+	var fallbackPlatform Platform
+
+	for _, m := range list.Manifests {
+		// Skip entries that are not image manifests (nested indexes, artifacts)
+		if m.MediaType != "" && !isManifestMediaType(m.MediaType) {
+			continue
+		}
+		have := Platform{OS: m.Platform.OS, Architecture: m.Platform.Architecture, Variant: m.Platform.Variant}
+		if !want.Matches(have) {
+			continue
+		}
+		if normalizeVariant(have) == normalizeVariant(want) {
+			return m.Digest, have, true
+		}
+		if fallback == "" {
+			fallback, fallbackPlatform = m.Digest, have
+		}
+	}
+
+	return fallback, fallbackPlatform, fallback != ""
+}
+
+// parseAuthHeader extracts the scheme, realm and service from a WWW-Authenticate header.
 // Example: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
-func parseAuthHeader(header string) (realm, service string) {
-	// Remove "Bearer " prefix
-	header = strings.TrimPrefix(header, "Bearer ")
+// Example: Basic realm="Registry Realm"
+func parseAuthHeader(header string) (scheme, realm, service string) {
+	// Split off the scheme ("Bearer" or "Basic")
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
 
 	// Parse key="value" pairs
-	for part := range strings.SplitSeq(header, ",") {
+	for part := range strings.SplitSeq(params, ",") {
 		part = strings.TrimSpace(part)
 		if val, ok := strings.CutPrefix(part, "realm="); ok {
 			realm = strings.Trim(val, "\"")
@@ -267,7 +833,7 @@ func parseAuthHeader(header string) (realm, service string) {
 		}
 	}
 
-	return realm, service
+	return scheme, realm, service
 }
 
 // doRequest makes an authenticated request to the registry.
@@ -276,32 +842,71 @@ func (c *RegistryClient) doRequest(method, url string) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
+	return c.do(req)
+}
 
-	// Add auth token if we have one
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
+// do sends a request with registry authentication attached.
+// Bearer tokens close to expiry are renewed first, and a request rejected
+// with 401 (token expired mid-pull) is retried once with a fresh token.
+func (c *RegistryClient) do(req *http.Request) (*http.Response, error) {
+	if err := c.authorize(req, false); err != nil {
+		return nil, err
 	}
 
-	return c.client.Do(req)
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.scheme != "Bearer" {
+		return resp, err
+	}
+
+	// Token was rejected: renew it and retry once
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.authorize(retry, true); err != nil {
+		return nil, err
+	}
+	return c.client.Do(retry)
 }
 
-// fetchManifestByDigest fetches a manifest by its digest.
-func (c *RegistryClient) fetchManifestByDigest(digest string) (*ManifestV2, error) {
-	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s",
-		c.ref.Registry, c.ref.Repository, digest)
+// authorize sets the Authorization header for req.
+// If force is set or the current bearer token is about to expire, a new one is requested.
+func (c *RegistryClient) authorize(req *http.Request, force bool) error {
+	switch c.scheme {
+	case "Basic":
+		req.SetBasicAuth(c.username, c.password)
+
+	case "Bearer":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if force || time.Now().Add(tokenRefreshMargin).After(c.expiresAt) {
+			if err := c.refreshToken(); err != nil {
+				return fmt.Errorf("refresh token: %w", err)
+			}
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return nil
+}
+
+// fetchManifestByDigest fetches the image manifest an index entry points to.
+// Both Docker and OCI manifests are accepted; the content is verified against
+// the digest and the manifest is validated before it is returned.
+func (c *RegistryClient) fetchManifestByDigest(digest string) (*RemoteManifest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s",
+		c.endpoint, c.ref.Repository, digest)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create manifest request: %w", err)
 	}
 
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
-	}
-
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	req.Header.Set("Accept", MediaTypeOCIManifest+", "+MediaTypeDockerManifest)
 
-	resp, err := c.client.Do(req)
+	resp, source, err := c.fetch(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch manifest by digest: %w", err)
 	}
@@ -312,10 +917,111 @@ func (c *RegistryClient) fetchManifestByDigest(digest string) (*ManifestV2, erro
 		return nil, fmt.Errorf("manifest by digest failed: %d: %s", resp.StatusCode, body)
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest body: %w", err)
+	}
+	if actual := digestBytes(body); actual != digest {
+		return nil, fmt.Errorf("manifest digest mismatch: expected %s, got %s", digest, actual)
+	}
+
 	var manifest ManifestV2
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+	if err := json.Unmarshal(body, &manifest); err != nil {
 		return nil, fmt.Errorf("parse manifest: %w", err)
 	}
+	if err := validateManifest(&manifest, resp.Header.Get("Content-Type")); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", shortDigest(digest), err)
+	}
+	c.recordServed(digest, source)
 
+	return &RemoteManifest{ManifestV2: &manifest, Raw: body, Digest: digest}, nil
+}
+
+// FetchManifestDescriptor fetches the manifest or index with digest and
+// returns a descriptor for it, e.g. to name it as the subject of an artifact.
+// The content is verified against the digest.
+func (c *RegistryClient) FetchManifestDescriptor(digest string) (Descriptor, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s",
+		c.endpoint, c.ref.Repository, digest)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("create manifest request: %w", err)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("fetch manifest by digest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return Descriptor{}, fmt.Errorf("manifest by digest failed: %d: %s", resp.StatusCode, body)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("read manifest body: %w", err)
+	}
+	if actual := digestBytes(body); actual != digest {
+		return Descriptor{}, fmt.Errorf("manifest digest mismatch: expected %s, got %s", digest, actual)
+	}
+
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return Descriptor{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	mediaType, err := documentMediaType(probe.MediaType, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if mediaType == "" {
+		mediaType = MediaTypeOCIManifest
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(body))}, nil
+}
+
+// maxArtifactManifestSize bounds artifact manifests read from a registry;
+// signature artifacts list a handful of small blobs.
+const maxArtifactManifestSize = 1 << 20
+
+// FetchArtifact fetches the OCI artifact manifest tagged tag.
+// Unlike image manifests, artifacts are not validated as container images.
+// Returns nil (and no error) if the registry has no such tag.
+func (c *RegistryClient) FetchArtifact(tag string) (*ManifestV2, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s",
+		c.endpoint, c.ref.Repository, tag)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create manifest request: %w", err)
+	}
+	req.Header.Set("Accept", MediaTypeOCIManifest)
+
+	resp, _, err := c.fetch(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch artifact: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("artifact request failed: %d: %s", resp.StatusCode, body)
+	}
+
+	var manifest ManifestV2
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxArtifactManifestSize)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("parse artifact %s: %w", tag, err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return nil, fmt.Errorf("artifact %s: unsupported manifest schema version %d", tag, manifest.SchemaVersion)
+	}
 	return &manifest, nil
 }
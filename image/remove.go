@@ -3,83 +3,135 @@ package image
 import (
 	"fmt"
 	"os"
-	"path/filepath"
-	"slices"
+	"strings"
+	"time"
 )
 
-// RemoveImage removes an image by reference (name:tag or ID).
-// Deletes the image metadata and removes layers that are no longer referenced.
+// ContainerImage is the image a container was created from, as recorded in
+// its state. The image package does not read container state itself; callers
+// pass these in so images and layers in use are left alone.
+type ContainerImage struct {
+	Container string   // Container name
+	ImageID   string   // ID of the image ("" for containers created before image IDs were recorded)
+	LayerDirs []string // Layer directories the container's overlay uses, bottom to top
+}
+
+// uses reports whether the container was created from the image.
+// Containers without a recorded image ID are matched by their layers.
+func (c ContainerImage) uses(meta *ImageMetadata) bool {
+	if c.ImageID != "" {
+		return c.ImageID == meta.ID
+	}
+	return len(meta.DiffIDs) > 0 && sameLayers(meta.DiffIDs, c.LayerDirs)
+}
+
+// RemoveOptions configures RemoveImage.
+type RemoveOptions struct {
+	Force      bool             // Delete the image even if containers use it (their layers are kept)
+	Containers []ContainerImage // All containers, running or stopped
+}
+
+// RemoveImage removes an image reference, deleting the image once no tags remain.
+// Removing by tag only untags the image while other tags still reference it.
+// Removing by ID or digest ("name@sha256:...") is refused if the image has more
+// than one tag.
+// Deleting an image that containers were created from is refused unless
+// opts.Force is set; the layers those containers run on are never removed.
+// When the image is deleted, snapshots and blobs no other image references
+// are removed too, except those stored or reused within pruneGracePeriod:
+// a pull in progress may be about to save an image that needs them, so they
+// are left to `image prune`.
 //
 // Parameters:
-//   - ref: image reference ("name:tag") or image ID (full or short)
+//   - ref: image reference ("name:tag" or "name@sha256:...") or image ID (full or short)
+//   - opts: whether to force removal, and the containers that may use the image
 //
 // Returns:
-//   - error if image not found or removal fails
-func RemoveImage(ref string) error {
-	// Try to find the image by name:tag first
-	name, tag := ParseImageRef(ref)
-	meta, err := LoadMetadata(name, tag)
+//   - untagged: the "name:tag" references removed
+//   - deleted: ID of the deleted image ("" if it is still tagged)
+//   - error if image not found, in use, or removal fails
+func RemoveImage(ref string, opts RemoveOptions) (untagged []string, deleted string, err error) {
+	// Step 1: Find the image by name:tag or ID
+	meta, err := ResolveImage(ref)
 	if err != nil {
-		// Not found by name:tag, try to find by ID
-		meta, err = findImageByID(ref)
-		if err != nil {
-			return fmt.Errorf("image %s not found", ref)
-		}
+		return nil, "", err
+	}
+	tags, err := ImageTags(meta.ID)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Collect layers to potentially remove
-	layersToCheck := meta.Layers
-
-	// Remove image metadata directory
-	imageDir := ImageDir(meta.Name, meta.Tag)
-	if err := os.RemoveAll(imageDir); err != nil {
-		return fmt.Errorf("remove image metadata: %w", err)
+	// Step 2: Untag. By name only that tag goes; by ID or digest the image must have at most one tag
+	if meta.Name != "" {
+		untagged = []string{meta.Name + ":" + meta.Tag}
+	} else if len(tags) > 1 {
+		return nil, "", fmt.Errorf("image %s is referenced by multiple tags (%s), remove them by name",
+			ref, strings.Join(tags, ", "))
+	} else {
+		untagged = tags
 	}
+	stillTagged := len(tags) > len(untagged)
 
-	// Clean up empty parent directory (name directory) if no more tags
-	nameDir := filepath.Dir(imageDir)
-	entries, _ := os.ReadDir(nameDir)
-	if len(entries) == 0 {
-		os.Remove(nameDir) // Best effort, ignore errors
+	// Step 3: An image still in use by containers is only deleted with force
+	if !stillTagged && !opts.Force {
+		var users []string
+		for _, c := range opts.Containers {
+			if c.uses(meta) {
+				users = append(users, c.Container)
+			}
+		}
+		if len(users) > 0 {
+			return nil, "", fmt.Errorf("image %s is in use by container %s: remove the container first or use --force",
+				ref, strings.Join(users, ", "))
+		}
 	}
 
-	// Remove layers that are no longer referenced by any image
-	for _, layerDigest := range layersToCheck {
-		if !isLayerReferenced(layerDigest) {
-			RemoveLayer(layerDigest)
+	for _, tagRef := range untagged {
+		if err := removeTag(tagRef); err != nil {
+			return nil, "", err
 		}
 	}
+	if stillTagged {
+		return untagged, "", nil
+	}
 
-	return nil
-}
+	// Step 4: Remove the image metadata directory
+	if err := os.RemoveAll(ImageDir(meta.ID)); err != nil {
+		return untagged, "", fmt.Errorf("remove image metadata: %w", err)
+	}
 
-// findImageByID searches for an image by full or short ID.
-// Returns the image metadata if found.
-func findImageByID(id string) (*ImageMetadata, error) {
-	images, err := ListImages()
+	// Step 5: Remove snapshots and blobs no longer referenced by any image or container
+	snapshots, blobs, err := referencedContent()
 	if err != nil {
-		return nil, err
+		return untagged, meta.ID, nil // Keep them if in doubt; `image prune` collects them later
 	}
-
-	for _, img := range images {
-		// Match full ID or prefix (short ID)
-		if img.ID == id || (len(id) >= 4 && len(img.ID) >= len(id) && img.ID[:len(id)] == id) {
-			return img, nil
+	markContainerLayers(snapshots, opts.Containers)
+	cutoff := time.Now().Add(-pruneGracePeriod)
+	for _, diffID := range meta.DiffIDs {
+		if snapshots[strings.TrimPrefix(diffID, "sha256:")] {
+			continue
+		}
+		// Age is checked under the lock, which a pull reusing the snapshot
+		// holds while it refreshes the marker (see touchSnapshot)
+		if unlock, err := lockSnapshot(diffID); err == nil {
+			if !snapshotModTime(diffID).After(cutoff) {
+				removeSnapshotLocked(diffID)
+			}
+			unlock()
 		}
 	}
-
-	return nil, fmt.Errorf("image not found")
-}
-
-// isLayerReferenced checks if any image references this layer.
-// Used to determine if a layer can be safely deleted.
-func isLayerReferenced(layerDigest string) bool {
-	images, err := ListImages()
-	if err != nil {
-		return true // Assume referenced on error (safer)
+	for _, digest := range meta.references() {
+		if blobs[strings.TrimPrefix(digest, "sha256:")] {
+			continue
+		}
+		// A pull unpacking the blob holds its lock
+		if unlock, err := lockContent(digest); err == nil {
+			if !contentModTime(digest).After(cutoff) {
+				removeContent(digest)
+			}
+			unlock()
+		}
 	}
 
-	return slices.ContainsFunc(images, func(img *ImageMetadata) bool {
-		return slices.Contains(img.Layers, layerDigest)
-	})
+	return untagged, meta.ID, nil
 }
@@ -0,0 +1,536 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Archive formats supported by Save and Load.
+const (
+	FormatDocker = "docker" // `docker save` archive: manifest.json + repositories
+	FormatOCI    = "oci"    // OCI image layout: oci-layout + index.json + blobs/sha256
+)
+
+// OCI annotations used in image layouts.
+const (
+	annotationRefName   = "org.opencontainers.image.ref.name" // Tag (or full reference) of an index entry
+	annotationImageName = "io.containerd.image.name"          // Full "name:tag" of an index entry
+)
+
+// dockerArchiveManifest is one entry of a `docker save` manifest.json.
+type dockerArchiveManifest struct {
+	Config   string   `json:"Config"`   // Path of the config file, e.g. "<hex>.json"
+	RepoTags []string `json:"RepoTags"` // e.g. ["alpine:3.19"]
+	Layers   []string `json:"Layers"`   // Paths of the layer tars, bottom to top
+}
+
+// ociIndex is the index.json of an OCI image layout.
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// ociDescriptor is a descriptor with the optional fields used in indexes.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociPlatform is the platform of an index entry.
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// archiveWriter receives the files of an image archive.
+// Implemented by a tarball writer and an image layout directory writer.
+type archiveWriter interface {
+	WriteFile(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+// Save writes a local image to an archive that Load (or `docker load`) can read.
+// Layers and config come from the content store when it has them, so the image
+// keeps its diff_ids, config and (in OCI layouts) manifest byte for byte.
+// Layers whose blobs are not stored are re-archived from their snapshots and
+// the config is regenerated with the matching diff_ids, so the image still
+// round-trips with layers and config intact. Blobs are verified as they are read.
+//
+// Formats:
+//   - FormatDocker: tarball with manifest.json, repositories, <hex>.json config
+//     and <hex>/layer.tar uncompressed layers
+//   - FormatOCI: OCI image layout with the stored manifest and blobs, or
+//     gzip-compressed layers and a generated manifest; written as a tarball,
+//     or as a directory if output is an existing directory or ends in "/"
+//
+// Parameters:
+//   - ref: local image reference ("name:tag")
+//   - output: path of the archive (or layout directory) to create
+//   - format: FormatDocker or FormatOCI
+//
+// Returns:
+//   - error: any error during save
+func Save(ref, output, format string) error {
+	// Step 1: Load the local image
+	meta, err := ResolveImage(ref)
+	if err != nil {
+		return err
+	}
+	if len(meta.DiffIDs) != len(meta.Layers) {
+		return fmt.Errorf("image %s has no layer diff_ids, pull or import it again", ref)
+	}
+	if format != FormatDocker && format != FormatOCI {
+		return fmt.Errorf("unsupported format %q (use %q or %q)", format, FormatDocker, FormatOCI)
+	}
+	if err := EnsureImageDirs(); err != nil {
+		return fmt.Errorf("ensure image dirs: %w", err)
+	}
+
+	// Step 2: Open the destination
+	var w archiveWriter
+	info, statErr := os.Stat(output)
+	if format == FormatOCI && (strings.HasSuffix(output, "/") || (statErr == nil && info.IsDir())) {
+		w, err = newDirArchiveWriter(output)
+	} else {
+		w, err = newTarArchiveWriter(output)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Step 3: Write the image
+	if format == FormatOCI {
+		err = saveOCI(meta, w)
+	} else {
+		err = saveDocker(meta, w)
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if statErr != nil {
+			os.RemoveAll(output) // Don't leave a half-written archive behind
+		}
+		return err
+	}
+	return nil
+}
+
+// saveDocker writes meta in `docker save` format.
+func saveDocker(meta *ImageMetadata, w archiveWriter) error {
+	// Step 1: Uncompressed layer tars, stored as "<diffID hex>/layer.tar"
+	layerPath := func(diffID string) string {
+		return strings.TrimPrefix(diffID, "sha256:") + "/layer.tar"
+	}
+	layerPaths := make([]string, len(meta.Layers))
+	diffIDs := make([]string, len(meta.Layers))
+	for i, digest := range meta.Layers {
+		archive := writeUncompressedLayer
+		if HasContent(digest) {
+			archive = func(_ string, dst io.Writer) (string, error) {
+				return decompressContent(dst, digest, meta.DiffIDs[i])
+			}
+		}
+		diffID, err := writeLayerFile(w, SnapshotDir(meta.DiffIDs[i]), layerPath, archive)
+		if err != nil {
+			return fmt.Errorf("save layer %s: %w", shortDigest(digest), err)
+		}
+		diffIDs[i] = diffID
+		layerPaths[i] = layerPath(diffID)
+	}
+
+	// Step 2: Config, named after its digest
+	configBlob, err := savedConfigBlob(meta, diffIDs)
+	if err != nil {
+		return err
+	}
+	configPath := strings.TrimPrefix(digestBytes(configBlob), "sha256:") + ".json"
+	if err := writeBytes(w, configPath, configBlob); err != nil {
+		return err
+	}
+
+	// Step 3: manifest.json and the legacy repositories file
+	// Images saved by ID or digest are loaded untagged
+	repoTags := []string{}
+	if meta.Name != "" {
+		repoTags = []string{meta.Name + ":" + meta.Tag}
+	}
+	manifest, err := json.Marshal([]dockerArchiveManifest{{
+		Config:   configPath,
+		RepoTags: repoTags,
+		Layers:   layerPaths,
+	}})
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	if err := writeBytes(w, "manifest.json", manifest); err != nil {
+		return err
+	}
+	if meta.Name == "" {
+		return nil
+	}
+
+	var topLayer string
+	if len(diffIDs) > 0 {
+		topLayer = strings.TrimPrefix(diffIDs[len(diffIDs)-1], "sha256:")
+	}
+	repositories, err := json.Marshal(map[string]map[string]string{meta.Name: {meta.Tag: topLayer}})
+	if err != nil {
+		return fmt.Errorf("marshal repositories: %w", err)
+	}
+	return writeBytes(w, "repositories", repositories)
+}
+
+// saveOCI writes meta as an OCI image layout.
+func saveOCI(meta *ImageMetadata, w archiveWriter) error {
+	// Step 1: Blobs of the stored manifest, or of a generated one
+	manifest, manifestType, manifestBlob, err := storedManifest(meta)
+	if err != nil {
+		return err
+	}
+	if manifest != nil {
+		err = saveStoredBlobs(manifest, w)
+	} else {
+		manifestType, manifestBlob, err = saveGeneratedBlobs(meta, w)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Step 2: The manifest blob
+	manifestDigest := digestBytes(manifestBlob)
+	if err := writeBytes(w, layoutBlobPath(manifestDigest), manifestBlob); err != nil {
+		return err
+	}
+
+	// Step 3: index.json pointing at the manifest, and the layout marker
+	platform, _ := ParsePlatform(meta.Platform)
+	if platform.OS == "" {
+		platform = HostPlatform()
+	}
+	desc := ociDescriptor{
+		MediaType: manifestType,
+		Digest:    manifestDigest,
+		Size:      int64(len(manifestBlob)),
+		Platform:  &ociPlatform{OS: platform.OS, Architecture: platform.Architecture, Variant: platform.Variant},
+	}
+	if meta.Name != "" {
+		desc.Annotations = map[string]string{
+			annotationImageName: meta.Name + ":" + meta.Tag,
+			annotationRefName:   meta.Tag,
+		}
+	}
+	index, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIIndex,
+		Manifests:     []ociDescriptor{desc},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal index: %w", err)
+	}
+	if err := writeBytes(w, "index.json", index); err != nil {
+		return err
+	}
+	return writeBytes(w, "oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+}
+
+// saveStoredBlobs writes the config and layer blobs of a stored manifest
+// from the content store. Non-distributable layers are included if they are
+// stored and left out otherwise, as in registries.
+func saveStoredBlobs(manifest *ManifestV2, w archiveWriter) error {
+	for _, layer := range manifest.Layers {
+		if !HasContent(layer.Digest) {
+			continue // storedManifest only allows this for non-distributable layers
+		}
+		if err := writeContentFile(w, layoutBlobPath(layer.Digest), layer.Digest); err != nil {
+			return fmt.Errorf("save layer %s: %w", shortDigest(layer.Digest), err)
+		}
+	}
+	return writeContentFile(w, layoutBlobPath(manifest.Config.Digest), manifest.Config.Digest)
+}
+
+// saveGeneratedBlobs writes the layer and config blobs of an image without
+// a complete stored manifest: stored layer blobs as they are, other layers
+// re-archived from their snapshots and gzip-compressed.
+// Returns the media type and bytes of an OCI manifest for them.
+func saveGeneratedBlobs(meta *ImageMetadata, w archiveWriter) (string, []byte, error) {
+	// Step 1: Layer blobs
+	layers := make([]Descriptor, len(meta.Layers))
+	diffIDs := make([]string, len(meta.Layers))
+	for i, digest := range meta.Layers {
+		desc, err := storedLayerDescriptor(digest)
+		if err != nil {
+			return "", nil, err
+		}
+		if desc.Digest != "" {
+			err = writeContentFile(w, layoutBlobPath(digest), digest)
+			diffIDs[i] = meta.DiffIDs[i]
+		} else {
+			diffIDs[i], err = writeLayerFile(w, SnapshotDir(meta.DiffIDs[i]), func(string) string {
+				return layoutBlobPath(desc.Digest)
+			}, func(srcDir string, dst io.Writer) (string, error) {
+				blobDigest, diffID, size, err := compressLayer(srcDir, dst)
+				desc = Descriptor{MediaType: MediaTypeOCILayerGzip, Digest: blobDigest, Size: size}
+				return diffID, err
+			})
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("save layer %s: %w", shortDigest(digest), err)
+		}
+		layers[i] = desc
+	}
+
+	// Step 2: Config
+	configBlob, err := savedConfigBlob(meta, diffIDs)
+	if err != nil {
+		return "", nil, err
+	}
+	configDesc := Descriptor{MediaType: MediaTypeOCIConfig, Digest: digestBytes(configBlob), Size: int64(len(configBlob))}
+	if err := writeBytes(w, layoutBlobPath(configDesc.Digest), configBlob); err != nil {
+		return "", nil, err
+	}
+
+	manifestBlob, err := json.Marshal(ManifestV2{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        layers,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("marshal manifest: %w", err)
+	}
+	return MediaTypeOCIManifest, manifestBlob, nil
+}
+
+// storedLayerDescriptor returns an OCI descriptor for a layer blob in the
+// content store, or an empty descriptor if the blob is not stored.
+func storedLayerDescriptor(digest string) (Descriptor, error) {
+	if !HasContent(digest) {
+		return Descriptor{}, nil
+	}
+	compression, err := contentCompression(digest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	size, err := contentSize(digest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	mediaType := map[string]string{
+		compressionNone: MediaTypeOCILayer,
+		compressionGzip: MediaTypeOCILayerGzip,
+		compressionZstd: MediaTypeOCILayerZstd,
+	}[compression]
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: size}, nil
+}
+
+// savedConfigBlob returns the config to save with layers of the given
+// diffIDs: the stored config blob if the layers are the image's own,
+// otherwise one regenerated for the re-archived layers.
+func savedConfigBlob(meta *ImageMetadata, diffIDs []string) ([]byte, error) {
+	if slices.Equal(diffIDs, meta.DiffIDs) && meta.ConfigDigest != "" && HasContent(meta.ConfigDigest) {
+		return readContent(meta.ConfigDigest)
+	}
+	return imageConfigBlob(meta, diffIDs)
+}
+
+// writeContentFile copies a blob from the content store into an archive,
+// verifying its digest as it is read.
+func writeContentFile(w archiveWriter, name, digest string) error {
+	verifier, err := newDigestVerifier(digest)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(ContentPath(digest))
+	if err != nil {
+		return fmt.Errorf("open blob %s: %w", shortDigest(digest), err)
+	}
+	defer file.Close()
+	size, err := contentSize(digest)
+	if err != nil {
+		return err
+	}
+
+	if err := w.WriteFile(name, size, io.TeeReader(file, verifier)); err != nil {
+		return err
+	}
+	if err := verifier.Verify(); err != nil {
+		return fmt.Errorf("blob %s is corrupt: %w", shortDigest(digest), err)
+	}
+	return nil
+}
+
+// decompressContent writes the uncompressed tar of a stored layer blob to
+// dst and checks it against the layer's diffID.
+// Returns the diffID.
+func decompressContent(dst io.Writer, digest, diffID string) (string, error) {
+	file, err := os.Open(ContentPath(digest))
+	if err != nil {
+		return "", fmt.Errorf("open blob %s: %w", shortDigest(digest), err)
+	}
+	defer file.Close()
+
+	stream, err := decompressStream(file, "")
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hasher), stream); err != nil {
+		return "", fmt.Errorf("decompress blob %s: %w", shortDigest(digest), err)
+	}
+	if actual := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); actual != diffID {
+		return "", fmt.Errorf("blob %s is corrupt: diffID is %s, expected %s", shortDigest(digest), actual, diffID)
+	}
+	return diffID, nil
+}
+
+// writeLayerFile archives a layer directory into a temporary file with
+// archive, then copies it into w under the name returned by nameFor.
+// The temporary file is needed because archive entries must be sized up front.
+// Returns the layer's diffID.
+func writeLayerFile(w archiveWriter, layerDir string, nameFor func(diffID string) string,
+	archive func(srcDir string, dst io.Writer) (string, error)) (string, error) {
+	file, err := os.CreateTemp(DownloadDir, "save-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp layer: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	diffID, err := archive(layerDir, file)
+	if err != nil {
+		return "", err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", fmt.Errorf("size temp layer: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("rewind temp layer: %w", err)
+	}
+	return diffID, w.WriteFile(nameFor(diffID), size, file)
+}
+
+// writeUncompressedLayer archives a layer directory as a plain tar.
+// Returns the diffID (the tar's digest).
+func writeUncompressedLayer(srcDir string, dst io.Writer) (string, error) {
+	hasher := sha256.New()
+	if err := writeLayerTar(io.MultiWriter(dst, hasher), srcDir); err != nil {
+		return "", fmt.Errorf("archive layer: %w", err)
+	}
+	return fmt.Sprintf("sha256:%x", hasher.Sum(nil)), nil
+}
+
+// writeBytes writes an in-memory file to an archive.
+func writeBytes(w archiveWriter, name string, data []byte) error {
+	return w.WriteFile(name, int64(len(data)), bytes.NewReader(data))
+}
+
+// layoutBlobPath returns the path of a blob inside an OCI image layout.
+// Example: layoutBlobPath("sha256:abc...") -> "blobs/sha256/abc..."
+func layoutBlobPath(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join("blobs", algorithm, hex)
+}
+
+// tarArchiveWriter writes archive files into a tarball.
+type tarArchiveWriter struct {
+	file *os.File
+	tw   *tar.Writer
+	dirs map[string]bool // Directory entries already written
+}
+
+// newTarArchiveWriter creates the tarball at path.
+func newTarArchiveWriter(path string) (*tarArchiveWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create archive: %w", err)
+	}
+	return &tarArchiveWriter{file: file, tw: tar.NewWriter(file), dirs: make(map[string]bool)}, nil
+}
+
+// WriteFile adds a regular file, preceded by entries for its parent directories.
+func (t *tarArchiveWriter) WriteFile(name string, size int64, r io.Reader) error {
+	var parents []string
+	for dir := filepath.Dir(name); dir != "." && !t.dirs[dir]; dir = filepath.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+	for _, dir := range parents {
+		if err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o755}); err != nil {
+			return fmt.Errorf("write %s: %w", dir, err)
+		}
+		t.dirs[dir] = true
+	}
+
+	if err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size}); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	if _, err := io.Copy(t.tw, r); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close finishes the tarball.
+func (t *tarArchiveWriter) Close() error {
+	err := t.tw.Close()
+	if closeErr := t.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// dirArchiveWriter writes archive files into a directory (OCI layout on disk).
+type dirArchiveWriter struct {
+	root string
+}
+
+// newDirArchiveWriter creates the layout directory at root.
+func newDirArchiveWriter(root string) (*dirArchiveWriter, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create layout dir: %w", err)
+	}
+	return &dirArchiveWriter{root: root}, nil
+}
+
+// WriteFile creates a file (and its parent directories) under the root.
+func (d *dirArchiveWriter) WriteFile(name string, size int64, r io.Reader) error {
+	path := filepath.Join(d.root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Dir(name), err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", name, err)
+	}
+	_, err = io.CopyN(file, r, size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close is a no-op; files are closed as they are written.
+func (d *dirArchiveWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,393 @@
+package image
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MediaTypeSignature is the artifact type of signature artifacts and the
+// media type of the signature documents they hold.
+const MediaTypeSignature = "application/vnd.minicontainer.signature.v1+json"
+
+// maxSignatureSize bounds signature documents read from a registry.
+const maxSignatureSize = 64 << 10
+
+// emptyConfig is the "{}" config blob of OCI artifacts.
+var emptyConfig = []byte("{}")
+
+// Signature is a detached ed25519 signature over a manifest digest.
+// It is stored as a JSON document, in a registry artifact or a sidecar file.
+type Signature struct {
+	Digest    string `json:"digest"`    // Signed manifest digest ("sha256:...")
+	KeyID     string `json:"key_id"`    // ID of the signing key (see KeyID)
+	Signature string `json:"signature"` // Base64 ed25519 signature over the digest string
+}
+
+// Verify reports whether the signature is valid for key.
+func (s Signature) Verify(key ed25519.PublicKey) bool {
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, []byte(s.Digest), sig)
+}
+
+// SignOptions configures how an image is signed.
+type SignOptions struct {
+	KeyPath string // PEM-encoded ed25519 private key (PKCS #8)
+	Output  string // Sidecar file or directory to write; empty pushes the signature to the registry
+}
+
+// Sign writes a detached ed25519 signature over the manifest digest of a
+// local image. The digest signed is the one the image's repository serves it
+// under (recorded when it was pulled or pushed), or else its stored manifest.
+//
+// By default the signature is pushed to the image's repository as an OCI
+// artifact tagged "sha256-<hex>.sig", with the signed manifest as its subject;
+// signatures by other keys already there are kept, and it is recorded with
+// the local image. With opts.Output it is added to a sidecar file instead
+// ("sha256-<hex>.sig" inside a directory).
+//
+// Parameters:
+//   - refStr: image reference ("name:tag" or "name@sha256:...")
+//   - opts: signing key and where to write the signature
+//
+// Returns:
+//   - *Signature: the new signature
+//   - string: where it was written (artifact reference or sidecar path)
+//   - error: any error while signing or writing the signature
+func Sign(refStr string, opts SignOptions) (*Signature, string, error) {
+	// Step 1: Find the image and the manifest digest to sign
+	meta, err := ResolveImage(refStr)
+	if err != nil {
+		return nil, "", err
+	}
+	ref, err := ParseReference(refStr)
+	if err != nil || (meta.Name == "" && ref.Digest == "") {
+		return nil, "", fmt.Errorf("sign %s by name, not by ID", refStr)
+	}
+	digest := meta.RepoDigest(ref.Name())
+	if digest == "" {
+		digest = meta.ManifestDigest
+	}
+	if digest == "" {
+		return nil, "", fmt.Errorf("image %s has no manifest digest, push it first", refStr)
+	}
+
+	// Step 2: Sign it
+	key, err := LoadSigningKey(opts.KeyPath)
+	if err != nil {
+		return nil, "", err
+	}
+	sig := Signature{
+		Digest:    digest,
+		KeyID:     KeyID(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(digest))),
+	}
+
+	// Step 3: Add it to a sidecar file, or push it to the registry
+	if opts.Output != "" {
+		path := opts.Output
+		if info, err := os.Stat(path); (err == nil && info.IsDir()) || strings.HasSuffix(path, "/") {
+			path = sidecarPath(path, digest)
+		}
+		sigs, err := readSidecar(path)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := writeSidecar(path, addSignature(sigs, sig)); err != nil {
+			return nil, "", err
+		}
+		return &sig, path, nil
+	}
+
+	client, err := NewRegistryClient(ref)
+	if err != nil {
+		return nil, "", err
+	}
+	client.actions = "push,pull"
+	if err := client.Authenticate(); err != nil {
+		return nil, "", fmt.Errorf("authenticate: %w", err)
+	}
+	if err := pushSignature(client, sig); err != nil {
+		return nil, "", err
+	}
+
+	// Step 4: Record it with the image, as a pull would, so it can be run
+	meta.AddSignature(sig)
+	if err := SaveMetadata(meta); err != nil {
+		return nil, "", fmt.Errorf("save metadata: %w", err)
+	}
+	return &sig, ref.Registry + "/" + ref.Repository + ":" + signatureTag(digest), nil
+}
+
+// pushSignature adds sig to the signature artifact of its digest in the
+// client's repository, creating the artifact if there is none.
+func pushSignature(client *RegistryClient, sig Signature) error {
+	// Step 1: The signed manifest must be in the repository
+	subject, err := client.FetchManifestDescriptor(sig.Digest)
+	if err != nil {
+		return fmt.Errorf("signed manifest: %w", err)
+	}
+
+	// Step 2: Keep the signatures already pushed, replacing ours
+	sigs, err := fetchSignatures(client, sig.Digest)
+	if err != nil {
+		return err
+	}
+	sigs = addSignature(sigs, sig)
+
+	// Step 3: Upload the signature documents and the empty config
+	artifact := ManifestV2{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  MediaTypeSignature,
+		Config:        Descriptor{MediaType: MediaTypeOCIEmpty, Digest: digestBytes(emptyConfig), Size: int64(len(emptyConfig))},
+		Subject:       &subject,
+	}
+	if err := pushBlob(client, artifact.Config, emptyConfig); err != nil {
+		return fmt.Errorf("push artifact config: %w", err)
+	}
+	for _, s := range sigs {
+		blob, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("marshal signature: %w", err)
+		}
+		desc := Descriptor{MediaType: MediaTypeSignature, Digest: digestBytes(blob), Size: int64(len(blob))}
+		if err := pushBlob(client, desc, blob); err != nil {
+			return fmt.Errorf("push signature: %w", err)
+		}
+		artifact.Layers = append(artifact.Layers, desc)
+	}
+
+	// Step 4: Tag the artifact after the signed digest
+	manifest, err := json.Marshal(artifact)
+	if err != nil {
+		return fmt.Errorf("marshal artifact: %w", err)
+	}
+	_, err = client.PutManifest(signatureTag(sig.Digest), MediaTypeOCIManifest, manifest)
+	return err
+}
+
+// fetchSignatures downloads the signatures of digest from the client's
+// repository. Returns nil if there are none.
+func fetchSignatures(client *RegistryClient, digest string) ([]Signature, error) {
+	artifact, err := client.FetchArtifact(signatureTag(digest))
+	if err != nil || artifact == nil {
+		return nil, err
+	}
+	if artifact.ArtifactType != MediaTypeSignature {
+		return nil, fmt.Errorf("%s is not a signature artifact (%q)", signatureTag(digest), artifact.ArtifactType)
+	}
+
+	var sigs []Signature
+	for _, layer := range artifact.Layers {
+		if layer.MediaType != MediaTypeSignature || layer.Size > maxSignatureSize {
+			continue
+		}
+		blob, err := fetchSmallBlob(client, layer.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("fetch signature %s: %w", shortDigest(layer.Digest), err)
+		}
+		var sig Signature
+		if err := json.Unmarshal(blob, &sig); err != nil {
+			return nil, fmt.Errorf("parse signature %s: %w", shortDigest(layer.Digest), err)
+		}
+		if sig.Digest == digest {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs, nil
+}
+
+// fetchSmallBlob downloads a blob of at most maxSignatureSize bytes and
+// verifies it against digest.
+func fetchSmallBlob(client *RegistryClient, digest string) ([]byte, error) {
+	body, _, err := client.FetchBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	blob, err := io.ReadAll(io.LimitReader(body, maxSignatureSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if actual := digestBytes(blob); actual != digest {
+		return nil, fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return blob, nil
+}
+
+// verifyPull checks an image being pulled against the trust policy before
+// any of its layers are downloaded. Signatures are looked up in the registry
+// and the signatures directory for both the manifest and the index digest.
+//
+// Returns the signatures found in the registry, to be recorded with the
+// image so `run` can verify it again, or an error if a scope in
+// TrustEnforce mode refuses the image.
+func verifyPull(client *RegistryClient, ref ImageReference, manifest *RemoteManifest) ([]Signature, error) {
+	policy, err := LoadTrustPolicy()
+	if err != nil {
+		return nil, err
+	}
+	scope := policy.ScopeFor(ref)
+	if scope == nil {
+		return nil, nil
+	}
+
+	digests := []string{manifest.Digest}
+	if manifest.RepoDigest != manifest.Digest {
+		digests = append(digests, manifest.RepoDigest)
+	}
+	var fetched, sigs []Signature
+	for _, digest := range digests {
+		remote, err := fetchSignatures(client, digest)
+		if err != nil {
+			return nil, fmt.Errorf("fetch signatures: %w", err)
+		}
+		fetched = append(fetched, remote...)
+		sidecar, err := readSidecar(sidecarPath(policy.SignaturesDir, digest))
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sidecar...)
+	}
+
+	keyID, err := scope.verify(digests, append(sigs, fetched...))
+	if err == nil {
+		fmt.Printf("  Signature verified: key %s (trust scope %s)\n", keyID, scope.Scope)
+	}
+	return fetched, scope.enforce(ref.Name(), err)
+}
+
+// LoadSigningKey reads a PEM-encoded ed25519 private key (PKCS #8), as
+// written by `openssl genpkey -algorithm ed25519`.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// LoadPublicKey reads a PEM-encoded ed25519 public key (PKIX), as written by
+// `openssl pkey -pubout`.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// readPEM reads the first PEM block of a key file.
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM-encoded", path)
+	}
+	return block, nil
+}
+
+// KeyID identifies a public key: the first 16 hex characters of the SHA-256
+// of the raw key.
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signatureTag returns the tag of the signature artifact of digest.
+// Example: signatureTag("sha256:abc...") -> "sha256-abc....sig"
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// sidecarPath returns the path of the sidecar file of digest in dir.
+func sidecarPath(dir, digest string) string {
+	return filepath.Join(dir, signatureTag(digest))
+}
+
+// readSidecar reads the signatures in a sidecar file (nil if it is absent).
+func readSidecar(path string) ([]Signature, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read signatures: %w", err)
+	}
+	var sigs []Signature
+	if err := json.Unmarshal(data, &sigs); err != nil {
+		return nil, fmt.Errorf("parse signatures %s: %w", path, err)
+	}
+	return sigs, nil
+}
+
+// writeSidecar replaces a sidecar file with sigs.
+func writeSidecar(path string, sigs []Signature) error {
+	data, err := json.MarshalIndent(sigs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal signatures: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create signatures dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write signatures: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write signatures: %w", err)
+	}
+	return nil
+}
+
+// sameSigner reports whether two signatures are by the same key over the
+// same digest.
+func (s Signature) sameSigner(other Signature) bool {
+	return s.KeyID == other.KeyID && s.Digest == other.Digest
+}
+
+// addSignature adds sig to sigs, replacing a signature by the same key over
+// the same digest.
+func addSignature(sigs []Signature, sig Signature) []Signature {
+	for i, s := range sigs {
+		if s.sameSigner(sig) {
+			sigs[i] = sig
+			return sigs
+		}
+	}
+	return append(sigs, sig)
+}
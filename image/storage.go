@@ -8,31 +8,115 @@ import (
 )
 
 const (
-	ImageBaseDir = "/var/lib/minicontainer/images"
+	ImageBaseDir    = "/var/lib/minicontainer/images"
+	ContentBaseDir  = "/var/lib/minicontainer/blobs"
+	SnapshotBaseDir = "/var/lib/minicontainer/snapshots"
+
+	// LayerBaseDir is the layer store of older versions, which kept only
+	// extracted layers keyed by their compressed digest. Its layers are moved
+	// to SnapshotBaseDir by migrateLayerStore.
 	LayerBaseDir = "/var/lib/minicontainer/layers"
 )
 
-// ImageDir returns the path to store an image's metadata.
-// Example: ImageDir("alpine", "latest") -> "/var/lib/minicontainer/images/alpine/latest"
-func ImageDir(name, tag string) string {
-	return filepath.Join(ImageBaseDir, name, tag)
+// ImageDir returns the path where an image's metadata and config are stored.
+// Images are content-addressed by ID; tags only reference them (see RepositoriesPath).
+// Example: ImageDir("abc123...") -> "/var/lib/minicontainer/images/sha256/abc123..."
+func ImageDir(id string) string {
+	return filepath.Join(ImageBaseDir, "sha256", strings.TrimPrefix(id, "sha256:"))
+}
+
+// RepositoriesPath is the file mapping "name:tag" references to image IDs.
+var RepositoriesPath = filepath.Join(ImageBaseDir, "repositories.json")
+
+// repositoriesLockPath serializes changes to RepositoriesPath across processes.
+var repositoriesLockPath = filepath.Join(ImageBaseDir, ".repositories.lock")
+
+// ContentPath returns the path of a blob in the content store. Manifests,
+// configs and compressed layers are stored there exactly as they were pulled,
+// loaded or created, so push and save can reproduce them byte for byte.
+// Example: ContentPath("sha256:abc123...") -> "/var/lib/minicontainer/blobs/sha256/abc123..."
+func ContentPath(digest string) string {
+	return filepath.Join(ContentBaseDir, "sha256", strings.TrimPrefix(digest, "sha256:"))
 }
 
-// LayerDir returns the path where a layer's contents are extracted.
-// Example: LayerDir("sha256:abc123...") -> "/var/lib/minicontainer/layers/abc123..."
-func LayerDir(digest string) string {
+// SnapshotDir returns the path where a layer's contents are extracted.
+// Snapshots are keyed by diffID (the digest of the uncompressed tar), so a
+// layer is extracted once however its blob is compressed.
+// Example: SnapshotDir("sha256:abc123...") -> "/var/lib/minicontainer/snapshots/abc123..."
+func SnapshotDir(diffID string) string {
 	// Strip the algorithm prefix (sha256:) to avoid colons in paths
-	cleanDigest := strings.TrimPrefix(digest, "sha256:")
-	return filepath.Join(LayerBaseDir, cleanDigest)
+	return filepath.Join(SnapshotBaseDir, strings.TrimPrefix(diffID, "sha256:"))
 }
 
-// EnsureImageDirs creates the base image and layer directories if they don't exist.
+// DownloadDir holds partially downloaded blobs so interrupted pulls can resume,
+// and temporary files of push, commit, save and load. It lives inside the
+// content store, on the same disk, so finished blobs are renamed into place;
+// its leading dot keeps it distinct from blobs.
+var DownloadDir = filepath.Join(ContentBaseDir, ".downloads")
+
+// PartialBlobPath returns the path of a blob's in-progress download.
+// Example: PartialBlobPath("sha256:abc123...") -> "/var/lib/minicontainer/blobs/.downloads/abc123..."
+func PartialBlobPath(digest string) string {
+	return filepath.Join(DownloadDir, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// contentLockDir holds one lock file per blob digest, serializing the
+// download and unpacking of a blob across processes.
+var contentLockDir = filepath.Join(ContentBaseDir, ".locks")
+
+// SnapshotStagingDir holds layers while they are extracted. A snapshot is
+// renamed into place only once its extraction has finished, so a crash
+// never leaves a half-populated snapshot directory in place.
+var SnapshotStagingDir = filepath.Join(SnapshotBaseDir, ".staging")
+
+// snapshotLockDir holds one lock file per diffID, serializing the creation
+// and removal of a snapshot across processes. Lock files (here and in
+// contentLockDir) are empty and never deleted: unlinking one while it is
+// held would let a second process lock a new file of the same name.
+var snapshotLockDir = filepath.Join(SnapshotBaseDir, ".locks")
+
+// snapshotMarkerDir holds a completion marker for every fully extracted snapshot.
+// Markers live outside the snapshot directories, which are mounted into containers.
+var snapshotMarkerDir = filepath.Join(SnapshotBaseDir, ".complete")
+
+// lockPath returns the lock file of a digest in a lock directory.
+func lockPath(dir, digest string) string {
+	return filepath.Join(dir, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// snapshotMarkerPath returns the completion marker of a snapshot.
+func snapshotMarkerPath(diffID string) string {
+	return filepath.Join(snapshotMarkerDir, strings.TrimPrefix(diffID, "sha256:"))
+}
+
+// EnsureImageDirs creates the image, content and snapshot directories if they
+// don't exist. A layer store left by an older version is migrated first.
 func EnsureImageDirs() error {
 	if err := os.MkdirAll(ImageBaseDir, 0o755); err != nil {
 		return fmt.Errorf("create image dir: %w", err)
 	}
-	if err := os.MkdirAll(LayerBaseDir, 0o755); err != nil {
-		return fmt.Errorf("create layer dir: %w", err)
+	if err := migrateLayerStore(); err != nil {
+		return fmt.Errorf("migrate layer store: %w", err)
+	}
+	for _, dir := range []string{filepath.Join(ContentBaseDir, "sha256"), DownloadDir, contentLockDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create content dir: %w", err)
+		}
+	}
+	for _, dir := range []string{SnapshotStagingDir, snapshotLockDir, snapshotMarkerDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create snapshot dir: %w", err)
+		}
 	}
 	return nil
 }
+
+// shortDigest returns the first 12 hex characters of a digest for display.
+// Example: shortDigest("sha256:abc123def456...") -> "abc123def456"
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) < 12 {
+		return hex
+	}
+	return hex[:12]
+}
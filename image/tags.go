@@ -0,0 +1,392 @@
+package image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// repositories maps "name:tag" references to image IDs.
+// Serialized to /var/lib/minicontainer/images/repositories.json.
+// Several tags may reference the same image; its metadata is stored once.
+type repositories struct {
+	Tags map[string]string `json:"tags"` // "name:tag" -> image ID
+}
+
+// TagImage creates the tag target for the image referenced by source.
+// If target already tags another image, it is moved to this one.
+//
+// Parameters:
+//   - source: existing image reference ("name:tag") or image ID (full or short)
+//   - target: new reference in "name" or "name:tag" format
+//
+// Returns:
+//   - *ImageMetadata: the tagged image, loaded through the new reference
+//   - error: if source is not found or the tag cannot be saved
+func TagImage(source, target string) (*ImageMetadata, error) {
+	// Validate the target before looking at the source
+	parsed, err := ParseTagReference(target)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ResolveImage(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setTag(parsed.Name(), parsed.Tag, meta.ID); err != nil {
+		return nil, err
+	}
+	meta.Name, meta.Tag = parsed.Name(), parsed.Tag
+	return meta, nil
+}
+
+// ResolveImage finds an image by "name:tag" reference, by repo digest or by
+// full or short ID. Lookups by digest or ID return metadata without Name and Tag.
+// When a reference carries both a tag and a digest, the digest decides.
+// Names are normalized, so "docker.io/library/alpine" finds "alpine".
+//
+// Parameters:
+//   - ref: "name[:tag]", "name[:tag]@sha256:<digest>", image ID,
+//     ID prefix (at least 4 characters) or "sha256:<id>"
+//
+// Returns:
+//   - *ImageMetadata: the image metadata
+//   - error: if no image matches (wrapping os.ErrNotExist), or an ID prefix is ambiguous
+func ResolveImage(ref string) (*ImageMetadata, error) {
+	// Step 1: Pinned references match the repo digest recorded at pull or push;
+	// otherwise tags take precedence
+	parsed, parseErr := ParseReference(ref)
+	if parseErr == nil {
+		if parsed.Digest != "" {
+			return resolveDigest(parsed)
+		}
+		meta, err := LoadMetadata(parsed.Name(), parsed.Tag)
+		if err == nil {
+			return meta, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	// Step 2: Fall back to an image ID prefix ("sha256:<id>" is not a valid name)
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if len(prefix) < 4 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		return nil, notFoundError(ref)
+	}
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, notFoundError(ref)
+	case 1:
+		return loadMetadataByID(matches[0])
+	default:
+		return nil, fmt.Errorf("image ID prefix %s is ambiguous", prefix)
+	}
+}
+
+// resolveDigest finds the image pulled or pushed as "name[:tag]@sha256:<digest>".
+func resolveDigest(ref ImageReference) (*ImageMetadata, error) {
+	repoDigest := ref.Name() + "@" + ref.Digest
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		meta, err := loadMetadataByID(id)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(meta.RepoDigests, repoDigest) {
+			return meta, nil
+		}
+	}
+	return nil, notFoundError(repoDigest)
+}
+
+// notFoundError reports a missing image. It matches os.ErrNotExist with
+// errors.Is, so callers can tell "not found" apart from read errors.
+type notFoundError string
+
+func (ref notFoundError) Error() string    { return "image " + string(ref) + " not found" }
+func (notFoundError) Is(target error) bool { return target == os.ErrNotExist }
+
+// ImageTags returns the "name:tag" references of an image, sorted.
+func ImageTags(id string) ([]string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	return repos.tagsOf(id), nil
+}
+
+// resolveTag returns the ID of the image tagged name:tag.
+// The error wraps os.ErrNotExist if there is no such tag.
+func resolveTag(name, tag string) (string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	id, ok := repos.Tags[name+":"+tag]
+	if !ok {
+		return "", notFoundError(name + ":" + tag)
+	}
+	return id, nil
+}
+
+// setTag points name:tag at the image with the given ID.
+func setTag(name, tag, id string) error {
+	return updateRepositories(func(repos *repositories) {
+		repos.Tags[name+":"+tag] = id
+	})
+}
+
+// removeTag deletes the tag with the "name:tag" key. The image itself is not touched.
+func removeTag(key string) error {
+	return updateRepositories(func(repos *repositories) {
+		delete(repos.Tags, key)
+	})
+}
+
+// updateRepositories applies change to the tag index and saves it, holding
+// the repositories lock throughout so concurrent pulls, tags and removals
+// never lose each other's changes.
+func updateRepositories(change func(repos *repositories)) error {
+	unlock, err := lockRepositories()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	repos, err := readRepositories()
+	if err != nil {
+		return err
+	}
+	change(repos)
+	return repos.save()
+}
+
+// lockRepositories takes the exclusive lock on the tag index.
+// Returns the function releasing the lock.
+func lockRepositories() (func(), error) {
+	unlock, _, err := flockFile(repositoriesLockPath, unix.LOCK_EX)
+	return unlock, err
+}
+
+// imageIDs returns the IDs of all stored images, tagged or not.
+func imageIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(ImageBaseDir, "sha256"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// tagsOf returns the references pointing at id, sorted.
+func (r *repositories) tagsOf(id string) []string {
+	var tags []string
+	for ref, tagged := range r.Tags {
+		if tagged == id {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// loadRepositories reads the tag index.
+// On first use, images stored in the old per-tag layout are migrated, and so
+// is the layer store of older versions (see migrateLayerStore).
+func loadRepositories() (*repositories, error) {
+	unlock, err := lockRepositories()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return readRepositories()
+}
+
+// readRepositories is loadRepositories for callers holding the repositories lock.
+func readRepositories() (*repositories, error) {
+	repos := &repositories{Tags: make(map[string]string)}
+
+	data, err := os.ReadFile(RepositoriesPath)
+	if os.IsNotExist(err) {
+		if err := migrateLegacyImages(repos); err != nil {
+			return nil, fmt.Errorf("migrate image store: %w", err)
+		}
+		if err := migrateLayerStore(); err != nil {
+			return nil, fmt.Errorf("migrate layer store: %w", err)
+		}
+		return repos, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read repositories: %w", err)
+	}
+
+	if err := json.Unmarshal(data, repos); err != nil {
+		return nil, fmt.Errorf("unmarshal repositories: %w", err)
+	}
+	if repos.Tags == nil {
+		repos.Tags = make(map[string]string)
+	}
+	if repos.normalize() {
+		if err := repos.save(); err != nil {
+			return nil, err
+		}
+	}
+	if err := migrateLayerStore(); err != nil {
+		return nil, fmt.Errorf("migrate layer store: %w", err)
+	}
+	return repos, nil
+}
+
+// normalize rewrites tags stored under a name that is not in normalized form,
+// e.g. "docker.io/library/alpine:latest" becomes "alpine:latest".
+// Tags that do not parse are left alone. Reports whether anything changed.
+func (r *repositories) normalize() bool {
+	changed := false
+	for key, id := range r.Tags {
+		parsed, err := ParseTagReference(key)
+		if err != nil || parsed.TagKey() == key {
+			continue
+		}
+		delete(r.Tags, key)
+		r.Tags[parsed.TagKey()] = id
+		changed = true
+	}
+	return changed
+}
+
+// save writes the tag index atomically, so readers never see a partial file.
+// The caller must hold the repositories lock.
+func (r *repositories) save() error {
+	if err := os.MkdirAll(ImageBaseDir, 0o755); err != nil {
+		return fmt.Errorf("create image dir: %w", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal repositories: %w", err)
+	}
+
+	file, err := os.CreateTemp(ImageBaseDir, "repositories-*.tmp")
+	if err != nil {
+		return fmt.Errorf("write repositories: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), RepositoriesPath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("write repositories: %w", err)
+	}
+	return nil
+}
+
+// migrateLegacyImages moves images from the old layout, where every tag had
+// its own copy of the metadata at images/<name>/<tag>/manifest.json, into the
+// ID-keyed store, and records their tags in repos.
+func migrateLegacyImages(repos *repositories) error {
+	// Step 1: Find legacy manifests (names may contain "/", so walk the tree)
+	var legacyDirs []string
+	err := filepath.WalkDir(ImageBaseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // No image store yet
+			}
+			return err
+		}
+		if d.IsDir() && path == filepath.Join(ImageBaseDir, "sha256") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "manifest.json" {
+			legacyDirs = append(legacyDirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil || len(legacyDirs) == 0 {
+		return err
+	}
+
+	// Step 2: Move each image's files under its ID and record the tag
+	for _, dir := range legacyDirs {
+		rel, err := filepath.Rel(ImageBaseDir, dir)
+		if err != nil {
+			return err
+		}
+		name, tag := filepath.Dir(rel), filepath.Base(rel)
+		if name == "." {
+			continue // Not a name/tag directory
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+		if err != nil {
+			return err
+		}
+		var meta ImageMetadata
+		if err := json.Unmarshal(data, &meta); err != nil || meta.ID == "" {
+			continue // Skip images with invalid metadata
+		}
+
+		if err := os.MkdirAll(ImageDir(meta.ID), 0o755); err != nil {
+			return err
+		}
+		for _, file := range []string{"manifest.json", "config.json"} {
+			err := os.Rename(filepath.Join(dir, file), filepath.Join(ImageDir(meta.ID), file))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		repos.Tags[name+":"+tag] = meta.ID
+
+		// Remove the tag directory and any name directories left empty
+		os.RemoveAll(dir)
+		for parent := filepath.Dir(dir); parent != ImageBaseDir; parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+
+	repos.normalize()
+	return repos.save()
+}
@@ -0,0 +1,261 @@
+package image
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DefaultTrustPolicyPath is where signature verification settings are read from.
+// Override with the MINICONTAINER_POLICY_CONFIG environment variable.
+const DefaultTrustPolicyPath = "/etc/minicontainer/policy.json"
+
+// DefaultSignaturesDir holds sidecar signature files, one per manifest digest
+// ("sha256-<hex>.sig", as written by `image sign --output`).
+const DefaultSignaturesDir = "/etc/minicontainer/signatures"
+
+// Verification modes of a trust scope.
+const (
+	TrustEnforce = "enforce" // Refuse unsigned images and images without a valid signature
+	TrustWarn    = "warn"    // Print a warning and use the image anyway
+)
+
+// TrustPolicy decides which images must be signed, and by whom.
+// Example /etc/minicontainer/policy.json:
+//
+//	{
+//	  "trust": [
+//	    {"scope": "ghcr.io/acme", "keys": ["/etc/minicontainer/keys/acme.pub"]},
+//	    {"scope": "localhost:5000", "keys": ["/etc/minicontainer/keys/dev.pub"], "mode": "warn"}
+//	  ]
+//	}
+type TrustPolicy struct {
+	// Trust lists the repositories whose images are verified. Images outside
+	// every scope are not verified.
+	Trust []TrustScope `json:"trust"`
+
+	// SignaturesDir overrides DefaultSignaturesDir.
+	SignaturesDir string `json:"signatures_dir"`
+}
+
+// TrustScope lists the keys trusted to sign the images of a set of repositories.
+type TrustScope struct {
+	// Scope is a registry ("ghcr.io", "localhost:5000") or repository name
+	// prefix ("ghcr.io/acme", "docker.io/library/alpine"). It matches whole
+	// path components; the most specific scope matching an image applies.
+	Scope string `json:"scope"`
+
+	// Keys are paths of PEM-encoded ed25519 public keys. A signature by any
+	// of them is enough.
+	Keys []string `json:"keys"`
+
+	// Mode is TrustEnforce (default) or TrustWarn.
+	Mode string `json:"mode"`
+}
+
+// errUnsigned reports an image without any signature for its manifest.
+var errUnsigned = errors.New("image is not signed")
+
+// LoadTrustPolicy reads the trust policy. A missing file yields an empty
+// policy, which verifies nothing.
+func LoadTrustPolicy() (*TrustPolicy, error) {
+	path := os.Getenv("MINICONTAINER_POLICY_CONFIG")
+	if path == "" {
+		path = DefaultTrustPolicyPath
+	}
+
+	policy := &TrustPolicy{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read trust policy: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, policy); err != nil {
+			return nil, fmt.Errorf("parse trust policy %s: %w", path, err)
+		}
+	}
+	if policy.SignaturesDir == "" {
+		policy.SignaturesDir = DefaultSignaturesDir
+	}
+
+	for i := range policy.Trust {
+		scope := &policy.Trust[i]
+		switch scope.Mode {
+		case "":
+			scope.Mode = TrustEnforce
+		case TrustEnforce, TrustWarn:
+		default:
+			return nil, fmt.Errorf("trust policy %s: scope %q: mode must be %q or %q, not %q",
+				path, scope.Scope, TrustEnforce, TrustWarn, scope.Mode)
+		}
+		if scope.Scope == "" || len(scope.Keys) == 0 {
+			return nil, fmt.Errorf("trust policy %s: every scope needs a scope and at least one key", path)
+		}
+	}
+	return policy, nil
+}
+
+// ScopeFor returns the most specific scope matching the repository of ref,
+// or nil if its images are not verified.
+// Scopes may spell Docker Hub names in any of the forms ParseReference
+// accepts for them: "alpine", "docker.io/library/alpine" or
+// "registry-1.docker.io/library/alpine".
+func (p *TrustPolicy) ScopeFor(ref ImageReference) *TrustScope {
+	names := []string{ref.Registry + "/" + ref.Repository, ref.Name()}
+	if ref.Registry == DefaultRegistry {
+		names = append(names, "docker.io/"+ref.Repository)
+	}
+
+	var best *TrustScope
+	for i, scope := range p.Trust {
+		prefix := strings.TrimSuffix(scope.Scope, "/")
+		matches := slices.ContainsFunc(names, func(name string) bool {
+			return name == prefix || strings.HasPrefix(name, prefix+"/")
+		})
+		if matches && (best == nil || len(prefix) > len(strings.TrimSuffix(best.Scope, "/"))) {
+			best = &p.Trust[i]
+		}
+	}
+	return best
+}
+
+// publicKeys loads the scope's keys, indexed by key ID.
+func (s *TrustScope) publicKeys() (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey)
+	for _, path := range s.Keys {
+		key, err := LoadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys[KeyID(key)] = key
+	}
+	return keys, nil
+}
+
+// verify checks that one of sigs is a valid signature over one of digests by
+// one of the scope's keys.
+//
+// Parameters:
+//   - digests: manifest digests the image is known by (manifest and index)
+//   - sigs: the signatures found for the image
+//
+// Returns the ID of the key that signed the image, or an error saying why
+// no signature is valid: errUnsigned, only signatures by untrusted keys, or
+// a signature by a trusted key that does not verify.
+func (s *TrustScope) verify(digests []string, sigs []Signature) (string, error) {
+	keys, err := s.publicKeys()
+	if err != nil {
+		return "", err
+	}
+
+	var invalid, untrusted []string
+	for _, sig := range sigs {
+		if !slices.Contains(digests, sig.Digest) {
+			continue // Signs another manifest
+		}
+		key, trusted := keys[sig.KeyID]
+		switch {
+		case !trusted:
+			untrusted = append(untrusted, sig.KeyID)
+		case sig.Verify(key):
+			return sig.KeyID, nil
+		default:
+			invalid = append(invalid, sig.KeyID)
+		}
+	}
+
+	switch {
+	case len(invalid) > 0:
+		return "", fmt.Errorf("invalid signature by key %s", strings.Join(invalid, ", "))
+	case len(untrusted) > 0:
+		return "", fmt.Errorf("no signature by a trusted key (signed by %s)", strings.Join(untrusted, ", "))
+	default:
+		return "", errUnsigned
+	}
+}
+
+// enforce applies the scope's mode to the outcome of verifying the image name.
+// In TrustWarn mode a failure is printed as a warning and nil is returned.
+func (s *TrustScope) enforce(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("signature verification failed for %s: %w", name, err)
+	if s.Mode == TrustWarn {
+		fmt.Fprintf(os.Stderr, "  Warning: %v\n", err)
+		return nil
+	}
+	return err
+}
+
+// VerifyImage checks a local image against the trust policy before it is
+// run. Every repository the image is tagged in or was pulled or pushed as
+// is looked up in the policy, so running an image by ID is no way around it.
+// The signatures fetched when the image was pulled and sidecar files in the
+// signatures directory are verified with the keys the policy lists today,
+// so keys removed from the policy stop being trusted at once.
+//
+// Parameters:
+//   - meta: the image to verify
+//
+// Returns:
+//   - error: if a scope in TrustEnforce mode applies and the image has no
+//     valid signature, or the policy or keys cannot be read
+func VerifyImage(meta *ImageMetadata) error {
+	policy, err := LoadTrustPolicy()
+	if err != nil {
+		return err
+	}
+	if len(policy.Trust) == 0 {
+		return nil
+	}
+
+	// Step 1: Every name the image is known by
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	names := repos.tagsOf(meta.ID)
+	for _, repoDigest := range meta.RepoDigests {
+		name, _, _ := strings.Cut(repoDigest, "@")
+		names = append(names, name)
+	}
+
+	// Step 2: The manifest digests it can be signed under, and its signatures
+	digests := meta.manifestDigests()
+	sigs := append([]Signature{}, meta.Signatures...)
+	for _, digest := range digests {
+		sidecar, err := readSidecar(sidecarPath(policy.SignaturesDir, digest))
+		if err != nil {
+			return err
+		}
+		sigs = append(sigs, sidecar...)
+	}
+
+	// Step 3: Verify against the scope of every name
+	checked := make(map[*TrustScope]bool)
+	for _, name := range names {
+		ref, err := ParseReference(name)
+		if err != nil {
+			continue
+		}
+		scope := policy.ScopeFor(ref)
+		if scope == nil || checked[scope] {
+			continue
+		}
+		checked[scope] = true
+
+		_, err = scope.verify(digests, sigs)
+		if errors.Is(err, errUnsigned) && len(meta.Signatures) == 0 {
+			err = fmt.Errorf("%w (sign it, or pull it again to fetch its signatures)", err)
+		}
+		if err := scope.enforce(ref.Name(), err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
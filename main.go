@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/hwang-fu/minicontainer/build"
 	"github.com/hwang-fu/minicontainer/cmd"
 	"github.com/hwang-fu/minicontainer/container"
 )
@@ -95,14 +96,52 @@ func main() {
 		cmd.RunPrune()
 
 	case "images":
-		cmd.RunImages()
+		showDigests := len(os.Args) > 2 && os.Args[2] == "--digests"
+		cmd.RunImages(showDigests)
 
 	case "rmi":
+		force := len(os.Args) > 2 && (os.Args[2] == "-f" || os.Args[2] == "--force")
+		args := os.Args[2:]
+		if force {
+			args = args[1:]
+		}
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: minicontainer rmi [-f] <image>")
+			os.Exit(1)
+		}
+		cmd.RunRmi(args[0], force)
+
+	case "image":
 		if len(os.Args) < 3 {
-			fmt.Fprintln(os.Stderr, "usage: minicontainer rmi <image>")
+			fmt.Fprintln(os.Stderr, "usage: minicontainer image inspect|history|prune|sign [options]")
+			os.Exit(1)
+		}
+		switch os.Args[2] {
+		case "inspect", "history":
+			if len(os.Args) < 4 {
+				fmt.Fprintf(os.Stderr, "usage: minicontainer image %s <image>\n", os.Args[2])
+				os.Exit(1)
+			}
+			if os.Args[2] == "inspect" {
+				cmd.RunImageInspect(os.Args[3])
+			} else {
+				cmd.RunImageHistory(os.Args[3])
+			}
+		case "prune":
+			cmd.RunImagePrune(os.Args[3:])
+		case "sign":
+			cmd.RunImageSign(os.Args[3:])
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown image command: %s\n", os.Args[2])
 			os.Exit(1)
 		}
-		cmd.RunRmi(os.Args[2])
+
+	case "tag":
+		if len(os.Args) < 4 {
+			fmt.Fprintln(os.Stderr, "usage: minicontainer tag <source> <target>")
+			os.Exit(1)
+		}
+		cmd.RunTag(os.Args[2], os.Args[3])
 
 	case "import":
 		if len(os.Args) < 4 {
@@ -111,6 +150,12 @@ func main() {
 		}
 		cmd.RunImport(os.Args[2], os.Args[3])
 
+	case "save":
+		cmd.RunSave(os.Args[2:])
+
+	case "load":
+		cmd.RunLoad(os.Args[2:])
+
 	case "inspect":
 		if len(os.Args) < 3 {
 			fmt.Fprintln(os.Stderr, "usage: minicontainer inspect <container>")
@@ -120,10 +165,32 @@ func main() {
 
 	case "pull":
 		if len(os.Args) < 3 {
-			fmt.Fprintln(os.Stderr, "usage: minicontainer pull <image>")
+			fmt.Fprintln(os.Stderr, "usage: minicontainer pull [options] <image>")
 			os.Exit(1)
 		}
-		cmd.RunPull(os.Args[2])
+		cmd.RunPull(os.Args[2:])
+
+	case "push":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: minicontainer push <image>")
+			os.Exit(1)
+		}
+		cmd.RunPush(os.Args[2])
+
+	case "login":
+		cmd.RunLogin(os.Args[2:])
+
+	case "logout":
+		cmd.RunLogout(os.Args[2:])
+
+	case "export":
+		cmd.RunExport(os.Args[2:])
+
+	case "commit":
+		cmd.RunCommit(os.Args[2:])
+
+	case "build":
+		build.RunBuild(os.Args[2:])
 
 	case "logs":
 		if len(os.Args) < 3 {
@@ -153,12 +220,22 @@ func printUsage() {
 	fmt.Println("  ps       List containers")
 	fmt.Println("  logs     Fetch the logs of a container")
 	fmt.Println("  inspect  Display detailed container information")
+	fmt.Println("  export   Export a container's filesystem as a tar archive")
+	fmt.Println("  commit   Create an image from a container's changes")
 	fmt.Println()
 	fmt.Println("Image Commands:")
 	fmt.Println("  images   List local images")
+	fmt.Println("  build    Build an image from a Dockerfile")
 	fmt.Println("  pull     Pull an image from a registry")
+	fmt.Println("  push     Push an image to a registry")
 	fmt.Println("  import   Import a tarball as an image")
+	fmt.Println("  save     Save an image to a tar archive")
+	fmt.Println("  load     Load images from a tar archive")
 	fmt.Println("  rmi      Remove an image")
+	fmt.Println("  tag      Create a tag that refers to an image")
+	fmt.Println("  image    Inspect, sign or prune images, or show their history")
+	fmt.Println("  login    Log in to a registry")
+	fmt.Println("  logout   Log out from a registry")
 	fmt.Println()
 	fmt.Println("Other Commands:")
 	fmt.Println("  prune    Remove stale overlay directories")
@@ -170,9 +247,10 @@ func printUsage() {
 func printCommandHelp(command string) {
 	switch command {
 	case "run":
-		fmt.Println("Usage: minicontainer run [options] <image|--rootfs path> <command> [args...]")
+		fmt.Println("Usage: minicontainer run [options] <image|--rootfs path> [command] [args...]")
 		fmt.Println()
 		fmt.Println("Create and run a container")
+		fmt.Println("When running an image, the command defaults to the image's Entrypoint and Cmd")
 		fmt.Println()
 		fmt.Println("Options:")
 		fmt.Println("  --rootfs PATH         Container root filesystem")
@@ -212,18 +290,137 @@ func printCommandHelp(command string) {
 		fmt.Println("Usage: minicontainer inspect <container>")
 		fmt.Println()
 		fmt.Println("Display detailed container information as JSON")
+	case "export":
+		fmt.Println("Usage: minicontainer export [-o <file>] <container>")
+		fmt.Println()
+		fmt.Println("Export a container's filesystem as a flat tar archive (running or stopped)")
+		fmt.Println("The archive can be imported again with 'minicontainer import'")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -o, --output FILE     Write to FILE instead of stdout")
+	case "commit":
+		fmt.Println("Usage: minicontainer commit [options] <container> <name[:tag]>")
+		fmt.Println()
+		fmt.Println("Create an image from a container's changes (running or stopped)")
+		fmt.Println("The container's writable layer is added on top of the image it was created from")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -m, --message MSG     Commit message, recorded in the image history")
+		fmt.Println("  -c, --change INSTR    Apply a Dockerfile instruction to the image config")
+		fmt.Println("                        (CMD, ENTRYPOINT, ENV, WORKDIR, USER, EXPOSE, LABEL)")
+		fmt.Println()
+		fmt.Println("Example: minicontainer commit -c 'CMD [\"nginx\"]' -m 'Install nginx' web mynginx:v1")
 	case "pull":
-		fmt.Println("Usage: minicontainer pull <image>")
+		fmt.Println("Usage: minicontainer pull [options] <image>")
 		fmt.Println()
 		fmt.Println("Pull an image from a registry")
+		fmt.Println("Interrupted layer downloads resume on the next pull")
+		fmt.Println("Pin a manifest with name@sha256:<digest> or name:tag@sha256:<digest>")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  --platform OS/ARCH[/VARIANT]  Platform for multi-arch images (default: host)")
+		fmt.Println("  --max-concurrent-downloads N  Layers downloaded in parallel (default: 3)")
+		fmt.Println()
+		fmt.Println("Images in a scope of /etc/minicontainer/policy.json must be signed by one of")
+		fmt.Println("its keys (see 'minicontainer help image')")
+		fmt.Println()
+		fmt.Println("Mirrors listed in /etc/minicontainer/registries.json are tried first, e.g.")
+		fmt.Println("  {\"mirrors\": {\"docker.io\": [\"localhost:5000\"]}}")
+		fmt.Println("falling back to the registry when a mirror is unreachable or lacks the image")
+	case "push":
+		fmt.Println("Usage: minicontainer push <image>")
+		fmt.Println()
+		fmt.Println("Push a local image to a registry")
+		fmt.Println("Layers the registry already has are skipped")
+		fmt.Println("Example: minicontainer push localhost:5000/myapp:v1")
+	case "login":
+		fmt.Println("Usage: minicontainer login [options] [registry]")
+		fmt.Println()
+		fmt.Println("Log in to a registry (default: Docker Hub)")
+		fmt.Println("Credentials are stored in ~/.minicontainer/config.json (Docker config.json format)")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -u, --username USER   Username")
+		fmt.Println("  -p, --password PASS   Password or access token")
+		fmt.Println("  --password-stdin      Read the password from stdin")
+	case "logout":
+		fmt.Println("Usage: minicontainer logout [registry]")
+		fmt.Println()
+		fmt.Println("Remove stored credentials for a registry (default: Docker Hub)")
+	case "save":
+		fmt.Println("Usage: minicontainer save -o <file> [--format docker|oci] <image>")
+		fmt.Println()
+		fmt.Println("Save an image with its layers and config to a tar archive")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -o, --output FILE     Archive to write (for oci: a directory writes an OCI layout)")
+		fmt.Println("  --format FORMAT       docker (docker save archive, default) or oci (OCI image layout)")
+	case "load":
+		fmt.Println("Usage: minicontainer load -i <file>")
+		fmt.Println()
+		fmt.Println("Load images from a docker save archive or OCI image layout (tarball or directory)")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -i, --input FILE      Archive or OCI layout directory to read")
+	case "build":
+		fmt.Println("Usage: minicontainer build -t <name[:tag]> [-f <Dockerfile>] <context>")
+		fmt.Println()
+		fmt.Println("Build an image from a Dockerfile")
+		fmt.Println("Supported instructions: FROM, RUN, COPY, ADD (local files), ENV, WORKDIR,")
+		fmt.Println("USER, CMD, ENTRYPOINT, EXPOSE, LABEL")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -t, --tag NAME[:TAG]  Name of the built image")
+		fmt.Println("  -f, --file PATH       Dockerfile to use (default: <context>/Dockerfile)")
 	case "images":
-		fmt.Println("Usage: minicontainer images")
+		fmt.Println("Usage: minicontainer images [--digests]")
 		fmt.Println()
 		fmt.Println("List local images")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  --digests  Show the repo digest (manifest digest) of each image")
 	case "rmi":
-		fmt.Println("Usage: minicontainer rmi <image>")
+		fmt.Println("Usage: minicontainer rmi [-f] <image>")
+		fmt.Println()
+		fmt.Println("Remove an image reference (name:tag or ID)")
+		fmt.Println()
+		fmt.Println("Removing a tag only untags the image; the image and its unused layers")
+		fmt.Println("are deleted when its last tag is removed.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fmt.Println("  -f, --force   Delete the image even if containers were created from it")
+		fmt.Println()
+		fmt.Println("Without --force, deleting an image that a container (running or stopped)")
+		fmt.Println("uses is refused. With it, the layers such containers run on are kept.")
+	case "image":
+		fmt.Println("Usage: minicontainer image inspect <image>")
+		fmt.Println("       minicontainer image history <image>")
+		fmt.Println("       minicontainer image prune [-a] [--filter until=<time>]")
+		fmt.Println("       minicontainer image sign --key <file> [-o <file|dir>] <image>")
+		fmt.Println()
+		fmt.Println("inspect  Show image metadata, tags, config and layers as JSON")
+		fmt.Println("history  Show the steps that created each layer, newest first")
+		fmt.Println("prune    Remove untagged images, unreferenced layers and stale temp files")
+		fmt.Println("sign     Sign the image's manifest digest with an ed25519 private key")
+		fmt.Println()
+		fmt.Println("Prune options:")
+		fmt.Println("  -a, --all                 Remove all images not used by a container")
+		fmt.Println("  --filter until=<time>     Only images created before <time> (duration such as")
+		fmt.Println("                            24h, RFC 3339 timestamp, date or Unix seconds)")
+		fmt.Println()
+		fmt.Println("Layers used by containers, running or stopped, are never removed.")
+		fmt.Println()
+		fmt.Println("Sign options:")
+		fmt.Println("  --key FILE                PEM ed25519 private key (openssl genpkey -algorithm ed25519)")
+		fmt.Println("  -o, --output FILE|DIR     Write a sidecar signature file instead of pushing the")
+		fmt.Println("                            signature to the registry as an OCI artifact")
+		fmt.Println()
+		fmt.Println("pull and run verify images in the scopes of /etc/minicontainer/policy.json")
+		fmt.Println("against the public keys listed there.")
+	case "tag":
+		fmt.Println("Usage: minicontainer tag <source> <target>")
 		fmt.Println()
-		fmt.Println("Remove an image")
+		fmt.Println("Create a tag target that refers to the image source (name:tag or ID)")
 	case "import":
 		fmt.Println("Usage: minicontainer import <tarball> <name[:tag]>")
 		fmt.Println()
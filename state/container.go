@@ -29,6 +29,12 @@ type ContainerState struct {
 	CreatedAt  time.Time       `json:"created_at"`  // When container was created
 	ExitCode   int             `json:"exit_code"`   // Exit code (valid when stopped)
 	RootfsPath string          `json:"rootfs_path"` // Path to container rootfs
+	Image      string          `json:"image"`       // Image reference "name:tag" (empty with --rootfs)
+	ImageID    string          `json:"image_id"`    // Full ID of the image (empty with --rootfs)
+	Layers     []string        `json:"layers"`      // Image layer diffIDs, bottom to top
+	LowerDirs  []string        `json:"lower_dirs"`  // Overlay lower directories, bottom to top
+	UpperDir   string          `json:"upper_dir"`   // Overlay upper directory (kept after the container stops)
+	MergedDir  string          `json:"merged_dir"`  // Overlay mount point (mounted while running)
 }
 
 // StateBaseDir returns the base directory for all container state.
@@ -44,6 +50,11 @@ func ContainerDir(containerID string) string {
 	return StateBaseDir + "/" + containerID
 }
 
+// OverlayDir returns the directory holding a container's overlay upper/work/merged dirs.
+func OverlayDir(containerID string) string {
+	return ContainerDir(containerID) + "/overlay"
+}
+
 // SaveState writes the container state to disk as JSON.
 func SaveState(cs *ContainerState) error {
 	dir := ContainerDir(cs.ID)